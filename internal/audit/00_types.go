@@ -0,0 +1,60 @@
+package audit
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Event is a single audit record. Hash covers every other field plus the
+// previous event's hash, so any edit or removal breaks the chain.
+type Event struct {
+	Sequence      uint64                 `json:"sequence"`
+	Time          time.Time              `json:"time"`
+	Action        string                 `json:"action"`
+	Actor         string                 `json:"actor"`
+	Role          string                 `json:"role,omitempty"`
+	AuthMethod    string                 `json:"auth_method,omitempty"`
+	RemoteAddr    string                 `json:"remote_addr,omitempty"`
+	JobID         string                 `json:"job_id,omitempty"`
+	RepositoryURL string                 `json:"repository_url,omitempty"`
+	PlaybookPath  string                 `json:"playbook_path,omitempty"`
+	Ref           string                 `json:"ref,omitempty"`
+	CommitSHA     string                 `json:"commit_sha,omitempty"`
+	TargetHosts   string                 `json:"target_hosts,omitempty"`
+	Status        string                 `json:"status,omitempty"`
+	Detail        string                 `json:"detail,omitempty"`
+	Request       map[string]interface{} `json:"request,omitempty"`
+	PrevHash      string                 `json:"prev_hash"`
+	Hash          string                 `json:"hash"`
+}
+
+// Query selects events from the log. Zero values match everything.
+type Query struct {
+	Since  time.Time
+	Until  time.Time
+	Actor  string
+	Action string
+	JobID  string
+	Limit  int
+}
+
+// Verification is the result of checking the hash chain
+type Verification struct {
+	Valid    bool   `json:"valid"`
+	Events   uint64 `json:"events"`
+	BrokenAt uint64 `json:"broken_at,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Log is an append-only, hash-chained audit log stored as JSON lines
+type Log struct {
+	mu       sync.Mutex
+	path     string
+	file     *os.File
+	sequence uint64
+	lastHash string
+	logger   zerolog.Logger
+}
@@ -0,0 +1,397 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	logFileName = "audit.log"
+	// maxLineSize bounds a single event line when reading the log back
+	maxLineSize = 4 * 1024 * 1024
+	// maxEventSize bounds an encoded event when it is appended. Larger events
+	// drop their request payload, then have their text fields cut to
+	// maxFieldSize, which keeps every line under maxLineSize even when JSON
+	// escaping inflates the text.
+	maxEventSize = 1024 * 1024
+	maxFieldSize = 16 * 1024
+)
+
+// errLineTooLong reports a log line longer than maxLineSize
+var errLineTooLong = errors.New("line exceeds the maximum audit event size")
+
+// Open opens (or creates) the audit log in dir and resumes its hash chain.
+// A broken chain is reported but does not prevent new events being appended.
+func Open(dir string) (*Log, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create audit directory %s: %w", dir, err)
+	}
+
+	l := &Log{
+		path:   filepath.Join(dir, logFileName),
+		logger: log.With().Str("component", "audit").Logger(),
+	}
+
+	verification, last, err := l.scan()
+	if err != nil {
+		return nil, err
+	}
+	if !verification.Valid {
+		l.logger.Error().
+			Uint64("broken_at", verification.BrokenAt).
+			Str("error", verification.Error).
+			Msg("Audit log hash chain is broken")
+	}
+	if last != nil {
+		l.sequence = last.Sequence
+		l.lastHash = last.Hash
+	}
+
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log %s: %w", l.path, err)
+	}
+	l.file = file
+
+	// Terminate a line left partially written by a crash so new events start cleanly
+	if err := l.terminateLastLine(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	l.logger.Info().Str("path", l.path).Uint64("events", verification.Events).Msg("Audit log opened")
+	return l, nil
+}
+
+// Append chains the event to the log and writes it durably. Sequence, time
+// and hashes are assigned by the log.
+func (l *Log) Append(event Event) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	event.Sequence = l.sequence + 1
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	event.PrevHash = l.lastHash
+
+	event, err := capSize(event)
+	if err != nil {
+		return err
+	}
+
+	// Hash the event as it will read back from disk, so values such as
+	// request numbers hash identically when the chain is verified later
+	event, err = normalize(event)
+	if err != nil {
+		return err
+	}
+	hash, err := eventHash(event)
+	if err != nil {
+		return err
+	}
+	event.Hash = hash
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit event: %w", err)
+	}
+	data = append(data, '\n')
+
+	if _, err := l.file.Write(data); err != nil {
+		return fmt.Errorf("failed to write audit event: %w", err)
+	}
+	if err := l.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync audit log: %w", err)
+	}
+
+	l.sequence = event.Sequence
+	l.lastHash = event.Hash
+	return nil
+}
+
+// Query returns matching events, newest first
+func (l *Log) Query(q Query) ([]Event, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var events []Event
+	err := l.each(nil, func(event Event) error {
+		if !q.Since.IsZero() && event.Time.Before(q.Since) {
+			return nil
+		}
+		if !q.Until.IsZero() && event.Time.After(q.Until) {
+			return nil
+		}
+		if q.Actor != "" && event.Actor != q.Actor {
+			return nil
+		}
+		if q.Action != "" && event.Action != q.Action {
+			return nil
+		}
+		if q.JobID != "" && event.JobID != q.JobID {
+			return nil
+		}
+		events = append(events, event)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	if q.Limit > 0 && len(events) > q.Limit {
+		events = events[:q.Limit]
+	}
+	return events, nil
+}
+
+// Verify walks the whole log and checks every link of the hash chain
+func (l *Log) Verify() (Verification, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	verification, _, err := l.scan()
+	return verification, err
+}
+
+// Close closes the underlying file
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.file.Close()
+}
+
+// scan verifies the chain and returns the last event in the log
+func (l *Log) scan() (Verification, *Event, error) {
+	verification := Verification{Valid: true}
+	var last *Event
+
+	corrupt := func(line int, err error) {
+		if verification.Valid {
+			verification.Valid = false
+			verification.BrokenAt = verification.Events + 1
+			verification.Error = fmt.Sprintf("line %d: %v", line, err)
+		}
+	}
+
+	err := l.each(corrupt, func(event Event) error {
+		verification.Events++
+		if verification.Valid {
+			if problem := checkLink(event, last); problem != "" {
+				verification.Valid = false
+				verification.BrokenAt = event.Sequence
+				verification.Error = problem
+			}
+		}
+		current := event
+		last = &current
+		return nil
+	})
+
+	return verification, last, err
+}
+
+// checkLink validates an event against its predecessor
+func checkLink(event Event, prev *Event) string {
+	expectedSequence, expectedPrev := uint64(1), ""
+	if prev != nil {
+		expectedSequence, expectedPrev = prev.Sequence+1, prev.Hash
+	}
+
+	if event.Sequence != expectedSequence {
+		return fmt.Sprintf("expected sequence %d, found %d", expectedSequence, event.Sequence)
+	}
+	if event.PrevHash != expectedPrev {
+		return fmt.Sprintf("event %d does not link to the previous event", event.Sequence)
+	}
+	hash, err := eventHash(event)
+	if err != nil {
+		return err.Error()
+	}
+	if hash != event.Hash {
+		return fmt.Sprintf("event %d hash mismatch", event.Sequence)
+	}
+	return ""
+}
+
+// each decodes every event in the log in order. Lines that cannot be decoded
+// or are longer than maxLineSize are passed to corrupt (if set) and skipped.
+func (l *Log) each(corrupt func(int, error), fn func(Event) error) error {
+	file, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open audit log %s: %w", l.path, err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReaderSize(file, 64*1024)
+
+	line := 0
+	for {
+		data, err := readLine(reader)
+		if err == io.EOF {
+			return nil
+		}
+		line++
+		if errors.Is(err, errLineTooLong) {
+			if corrupt != nil {
+				corrupt(line, err)
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read audit log %s: %w", l.path, err)
+		}
+		if len(data) == 0 {
+			continue
+		}
+
+		var event Event
+		if err := json.Unmarshal(data, &event); err != nil {
+			if corrupt != nil {
+				corrupt(line, err)
+			}
+			continue
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+}
+
+// readLine returns the next line without its line ending. A line longer than
+// maxLineSize is consumed and reported as errLineTooLong.
+func readLine(reader *bufio.Reader) ([]byte, error) {
+	var line []byte
+	tooLong := false
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if !tooLong {
+			if len(line)+len(chunk) > maxLineSize+1 {
+				tooLong, line = true, nil
+			} else {
+				line = append(line, chunk...)
+			}
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF && (len(line) > 0 || tooLong) {
+			err = nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if tooLong {
+			return nil, errLineTooLong
+		}
+		return bytes.TrimRight(line, "\r\n"), nil
+	}
+}
+
+// terminateLastLine appends a newline if the log does not end with one
+func (l *Log) terminateLastLine() error {
+	info, err := l.file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat audit log: %w", err)
+	}
+	if info.Size() == 0 {
+		return nil
+	}
+
+	last := make([]byte, 1)
+	if _, err := l.file.ReadAt(last, info.Size()-1); err != nil {
+		return fmt.Errorf("failed to read audit log: %w", err)
+	}
+	if last[0] == '\n' {
+		return nil
+	}
+
+	l.logger.Warn().Msg("Audit log ends with a partial line, terminating it")
+	_, err = l.file.Write([]byte("\n"))
+	return err
+}
+
+// capSize keeps an event's encoding within maxEventSize. The request payload
+// is replaced by a note of its size first; if that is not enough, text fields
+// are cut to maxFieldSize.
+func capSize(event Event) (Event, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return event, fmt.Errorf("failed to encode audit event: %w", err)
+	}
+	if len(data) <= maxEventSize {
+		return event, nil
+	}
+
+	if event.Request != nil {
+		request, _ := json.Marshal(event.Request)
+		event.Request = map[string]interface{}{"truncated": true, "size": len(request)}
+		if data, err = json.Marshal(event); err == nil && len(data) <= maxEventSize {
+			return event, nil
+		}
+	}
+
+	for _, field := range []*string{
+		&event.Action, &event.Actor, &event.Role, &event.AuthMethod, &event.RemoteAddr,
+		&event.JobID, &event.RepositoryURL, &event.PlaybookPath, &event.Ref,
+		&event.CommitSHA, &event.TargetHosts, &event.Status, &event.Detail,
+	} {
+		*field = truncate(*field, maxFieldSize)
+	}
+	return event, nil
+}
+
+// truncate cuts s to at most n bytes, without splitting a UTF-8 sequence,
+// and marks it as truncated
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "...(truncated)"
+}
+
+// normalize round-trips an event through its JSON encoding
+func normalize(event Event) (Event, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return event, fmt.Errorf("failed to encode audit event: %w", err)
+	}
+	var normalized Event
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return event, fmt.Errorf("failed to decode audit event: %w", err)
+	}
+	return normalized, nil
+}
+
+// eventHash hashes the canonical encoding of an event with its own hash cleared
+func eventHash(event Event) (string, error) {
+	event.Hash = ""
+	data, err := json.Marshal(event)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode audit event: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,24 @@
+package cron
+
+// Schedule is a parsed five-field cron expression (minute, hour, day of
+// month, month, day of week). Each field is a bit set of allowed values.
+type Schedule struct {
+	expr   string
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+	// domStar and dowStar record unrestricted day fields; when both day
+	// fields are restricted a day matches if either one does
+	domStar bool
+	dowStar bool
+}
+
+// bounds describes the allowed range and names of a field
+type bounds struct {
+	name  string
+	min   int
+	max   int
+	names map[string]int
+}
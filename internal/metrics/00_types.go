@@ -0,0 +1,70 @@
+package metrics
+
+import "sync"
+
+// Registry holds metrics and renders them in the Prometheus text format
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+// collector is a metric family that can render itself
+type collector interface {
+	write(w *familyWriter)
+}
+
+// series is one labelled time series of a vector
+type series struct {
+	labels []string
+	value  float64
+	// histogram state
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// vec tracks the series of a metric family. Once maxSeries distinct label
+// sets exist, further label sets are folded into a single overflow series so
+// cardinality stays bounded.
+type vec struct {
+	mu        sync.Mutex
+	name      string
+	help      string
+	kind      string
+	labels    []string
+	maxSeries int
+	series    map[string]*series
+	order     []string
+}
+
+// CounterVec is a monotonically increasing counter partitioned by labels
+type CounterVec struct {
+	vec
+}
+
+// GaugeVec is a value that can go up and down, partitioned by labels
+type GaugeVec struct {
+	vec
+}
+
+// HistogramVec samples observations into cumulative buckets, partitioned by labels
+type HistogramVec struct {
+	vec
+	buckets []float64
+}
+
+// GaugeFunc is a gauge whose value is read at scrape time
+type GaugeFunc struct {
+	name string
+	help string
+	fn   func() float64
+}
+
+// GaugeVecFunc is a labelled gauge whose values are read at scrape time.
+// The callback returns values keyed by the single label's value.
+type GaugeVecFunc struct {
+	name  string
+	help  string
+	label string
+	fn    func() map[string]float64
+}
@@ -0,0 +1,283 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// OverflowLabel replaces label values once a vector reaches its series limit
+	OverflowLabel = "other"
+	// DefaultMaxSeries is the series limit applied when none is given
+	DefaultMaxSeries = 200
+
+	contentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// DefaultBuckets suit durations from sub-second API calls to hour-long playbooks
+var DefaultBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 120, 300, 600, 1800, 3600}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.collectors = append(r.collectors, c)
+}
+
+// NewCounterVec registers a counter. maxSeries bounds the number of label
+// sets (0 means DefaultMaxSeries).
+func (r *Registry) NewCounterVec(name, help string, maxSeries int, labels ...string) *CounterVec {
+	c := &CounterVec{vec: newVec(name, help, "counter", maxSeries, labels)}
+	r.register(c)
+	return c
+}
+
+// NewGaugeVec registers a gauge. maxSeries bounds the number of label sets
+// (0 means DefaultMaxSeries).
+func (r *Registry) NewGaugeVec(name, help string, maxSeries int, labels ...string) *GaugeVec {
+	g := &GaugeVec{vec: newVec(name, help, "gauge", maxSeries, labels)}
+	r.register(g)
+	return g
+}
+
+// NewHistogramVec registers a histogram with the given upper bucket bounds.
+// maxSeries bounds the number of label sets (0 means DefaultMaxSeries).
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, maxSeries int, labels ...string) *HistogramVec {
+	sorted := append([]float64{}, buckets...)
+	sort.Float64s(sorted)
+	h := &HistogramVec{vec: newVec(name, help, "histogram", maxSeries, labels), buckets: sorted}
+	r.register(h)
+	return h
+}
+
+// NewGaugeFunc registers a gauge read from fn at scrape time
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{name: name, help: help, fn: fn}
+	r.register(g)
+	return g
+}
+
+// NewGaugeVecFunc registers a single-label gauge read from fn at scrape time
+func (r *Registry) NewGaugeVecFunc(name, help, label string, fn func() map[string]float64) *GaugeVecFunc {
+	g := &GaugeVecFunc{name: name, help: help, label: label, fn: fn}
+	r.register(g)
+	return g
+}
+
+func newVec(name, help, kind string, maxSeries int, labels []string) vec {
+	if maxSeries <= 0 {
+		maxSeries = DefaultMaxSeries
+	}
+	return vec{
+		name:      name,
+		help:      help,
+		kind:      kind,
+		labels:    labels,
+		maxSeries: maxSeries,
+		series:    make(map[string]*series),
+	}
+}
+
+// get returns the series for a label set, folding new label sets into the
+// overflow series once the limit is reached. The caller holds v.mu.
+func (v *vec) get(values []string) *series {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+
+	key := strings.Join(values, "\xff")
+	if s, ok := v.series[key]; ok {
+		return s
+	}
+
+	if len(v.series) >= v.maxSeries {
+		overflow := make([]string, len(values))
+		for i := range overflow {
+			overflow[i] = OverflowLabel
+		}
+		values = overflow
+		key = strings.Join(values, "\xff")
+		if s, ok := v.series[key]; ok {
+			return s
+		}
+	}
+
+	s := &series{labels: append([]string{}, values...)}
+	v.series[key] = s
+	v.order = append(v.order, key)
+	return s
+}
+
+// Inc adds one to the counter for the label values
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds delta (which must not be negative) to the counter for the label values
+func (c *CounterVec) Add(delta float64, values ...string) {
+	if delta < 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.get(values).value += delta
+}
+
+// Set sets the gauge for the label values
+func (g *GaugeVec) Set(value float64, values ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.get(values).value = value
+}
+
+// Observe records a sample for the label values
+func (h *HistogramVec) Observe(value float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.get(values)
+	if s.counts == nil {
+		s.counts = make([]uint64, len(h.buckets))
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+// ServeHTTP renders every registered metric
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+	r.Write(w)
+}
+
+// Write renders every registered metric in the Prometheus text format
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	collectors := append([]collector{}, r.collectors...)
+	r.mu.Unlock()
+
+	fw := &familyWriter{w: bufio.NewWriter(w)}
+	for _, c := range collectors {
+		c.write(fw)
+	}
+	return fw.w.Flush()
+}
+
+func (v *vec) write(fw *familyWriter) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	fw.header(v.name, v.help, v.kind)
+	for _, key := range v.order {
+		s := v.series[key]
+		fw.sample(v.name, v.labels, s.labels, "", "", s.value)
+	}
+}
+
+func (h *HistogramVec) write(fw *familyWriter) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fw.header(h.name, h.help, h.kind)
+	for _, key := range h.order {
+		s := h.series[key]
+		for i, bound := range h.buckets {
+			var count uint64
+			if s.counts != nil {
+				count = s.counts[i]
+			}
+			fw.sample(h.name+"_bucket", h.labels, s.labels, "le", formatFloat(bound), float64(count))
+		}
+		fw.sample(h.name+"_bucket", h.labels, s.labels, "le", "+Inf", float64(s.count))
+		fw.sample(h.name+"_sum", h.labels, s.labels, "", "", s.sum)
+		fw.sample(h.name+"_count", h.labels, s.labels, "", "", float64(s.count))
+	}
+}
+
+func (g *GaugeFunc) write(fw *familyWriter) {
+	fw.header(g.name, g.help, "gauge")
+	fw.sample(g.name, nil, nil, "", "", g.fn())
+}
+
+func (g *GaugeVecFunc) write(fw *familyWriter) {
+	values := g.fn()
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fw.header(g.name, g.help, "gauge")
+	for _, key := range keys {
+		fw.sample(g.name, []string{g.label}, []string{key}, "", "", values[key])
+	}
+}
+
+// familyWriter writes metric families in the text exposition format
+type familyWriter struct {
+	w *bufio.Writer
+}
+
+func (fw *familyWriter) header(name, help, kind string) {
+	fmt.Fprintf(fw.w, "# HELP %s %s\n", name, escapeHelp(help))
+	fmt.Fprintf(fw.w, "# TYPE %s %s\n", name, kind)
+}
+
+func (fw *familyWriter) sample(name string, labels, values []string, extraLabel, extraValue string, value float64) {
+	fw.w.WriteString(name)
+
+	pairs := make([]string, 0, len(labels)+1)
+	for i, label := range labels {
+		pairs = append(pairs, label+`="`+escapeLabel(values[i])+`"`)
+	}
+	if extraLabel != "" {
+		pairs = append(pairs, extraLabel+`="`+extraValue+`"`)
+	}
+	if len(pairs) > 0 {
+		fw.w.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+
+	fw.w.WriteString(" " + formatFloat(value) + "\n")
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
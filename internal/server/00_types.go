@@ -2,12 +2,22 @@ package server
 
 import (
 	"ansible-api/internal/ansible"
+	"ansible-api/internal/audit"
+	"ansible-api/internal/metrics"
 	"ansible-api/internal/vault"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"regexp"
 	"sync"
+	"text/template"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/rs/zerolog"
 	"golang.org/x/time/rate"
 )
@@ -22,17 +32,53 @@ type Config struct {
 	RetentionHours int    `json:"retention_hours"`
 	TempPatterns   string `json:"temp_patterns"`
 	RateLimit      int    `json:"rate_limit"`
+	DataDir        string `json:"data_dir"`
+	// RepoCacheMaxMB bounds the bare mirror cache; a negative value disables it
+	RepoCacheMaxMB int `json:"repo_cache_max_mb"`
+	// JobTimeoutSeconds is the default execution deadline for jobs that do not set one
+	JobTimeoutSeconds int `json:"job_timeout_seconds"`
 	// Drift detection settings
 	DriftCheckOnlyOnRepoChange bool `json:"drift_check_only_on_repo_change"`
-	DriftIgnoreDynamicContent  bool `json:"drift_ignore_dynamic_content"`
+	// DriftIgnoreDynamicContent enables the built-in dynamic content ignore rule; unset means enabled
+	DriftIgnoreDynamicContent *bool `json:"drift_ignore_dynamic_content"`
+	// Default drift schedule; DriftCron takes precedence over DriftIntervalSeconds
+	DriftIntervalSeconds int    `json:"drift_interval_seconds"`
+	DriftCron            string `json:"drift_cron"`
+	DriftJitterSeconds   int    `json:"drift_jitter_seconds"`
+	// DriftMaintenanceWindows is a JSON list of maintenance windows applied to every playbook
+	DriftMaintenanceWindows string `json:"drift_maintenance_windows"`
+	// DriftConcurrency bounds how many drift checks run at once
+	DriftConcurrency int `json:"drift_concurrency"`
+	// DriftRemediationPolicy is the default remediation policy: auto, manual or off
+	DriftRemediationPolicy string `json:"drift_remediation_policy"`
+	// Default drift remediation safeguards; 0 or a negative value removes a limit
+	DriftRemediationMaxPerHour       int `json:"drift_remediation_max_per_hour"`
+	DriftRemediationMaxHosts         int `json:"drift_remediation_max_hosts"`
+	DriftRemediationFailureThreshold int `json:"drift_remediation_failure_threshold"`
+	// DriftIgnoreRules is a JSON list of ignore rules applied to every playbook
+	DriftIgnoreRules string `json:"drift_ignore_rules"`
+	// NotificationWebhooks is a JSON list of webhook targets for job and drift events
+	NotificationWebhooks string `json:"notification_webhooks"`
+	// SMTP server and sender of email notifications
+	SMTPHost     string `json:"smtp_host"`
+	SMTPPort     int    `json:"smtp_port"`
+	SMTPUsername string `json:"smtp_username"`
+	SMTPPassword string `json:"smtp_password"`
+	SMTPFrom     string `json:"smtp_from"`
+	// NotificationEmailRecipients is a JSON list of email recipients and their subscriptions
+	NotificationEmailRecipients string `json:"notification_email_recipients"`
+	// Email subject and body templates (text/template) replacing the built-in ones
+	EmailSubjectTemplate string `json:"email_subject_template"`
+	EmailBodyTemplate    string `json:"email_body_template"`
 }
 
 type Server struct {
 	Router               *gin.Engine
 	Logger               zerolog.Logger
-	Jobs                 map[string]*Job
+	JobStore             JobStore
 	JobMutex             sync.RWMutex
 	JobQueue             chan *Job
+	QueueMutex           sync.Mutex // serialises sends to JobQueue so queued jobs can be taken out of it
 	RateLimiter          *rate.Limiter
 	GithubAppID          int
 	GithubInstallationID int
@@ -41,58 +87,568 @@ type Server struct {
 	VaultClient          *vault.VaultClient
 	AnsibleClient        *ansible.Client
 	JobProcessor         *JobProcessor
+	JobJanitor           *JobJanitor
+	Tasks                *TaskRegistry
+	Streams              *StreamRegistry
+	RepoCache            *RepoCache
+	Auth                 *Authenticator
+	Audit                *audit.Log
+	Metrics              *ServerMetrics
+	Drift                *DriftDetector
+	DriftState           DriftStateStore
+	Notifications        *Notifications
+	Webhooks             *WebhookNotifier
 	Config               *Config
 }
 
 // PlaybookRequest represents a request to run an Ansible playbook.
 type PlaybookRequest struct {
-	RepositoryURL string                       `json:"repository_url" validate:"required,httpsgit"`
-	PlaybookPath  string                       `json:"playbook_path" validate:"required"`
-	Inventory     map[string]map[string]string `json:"inventory"`
-	Environment   map[string]string            `json:"environment"`
-	Secrets       map[string]string            `json:"secrets"`
-	TargetHosts   string                       `json:"target_hosts"`
+	RepositoryURL string `json:"repository_url" validate:"required,httpsgit"`
+	PlaybookPath  string `json:"playbook_path" validate:"required,playbookpath"`
+	// Ref is the branch, tag or commit SHA to run; empty means the default branch
+	Ref         string                       `json:"ref" validate:"omitempty,gitref"`
+	Inventory   map[string]map[string]string `json:"inventory"`
+	Environment map[string]string            `json:"environment" validate:"omitempty,dive,keys,identifier,envname,endkeys"`
+	Secrets     map[string]string            `json:"secrets" validate:"omitempty,dive,keys,identifier,endkeys"`
+	TargetHosts string                       `json:"target_hosts"`
+	// TimeoutSeconds overrides the server default execution deadline for this run
+	TimeoutSeconds int `json:"timeout_seconds" validate:"omitempty,min=1,max=86400"`
+	// DriftSchedule overrides the default drift schedule for this playbook
+	DriftSchedule *DriftSchedule `json:"drift_schedule,omitempty"`
+	// RemediationPolicy overrides the default drift remediation policy for this playbook
+	RemediationPolicy string `json:"remediation_policy,omitempty" validate:"omitempty,oneof=auto manual off"`
+	// RemediationLimits overrides the default drift remediation safeguards for this playbook
+	RemediationLimits *RemediationLimits `json:"remediation_limits,omitempty"`
+	// DriftIgnoreRules are added to the global drift ignore rules for this playbook
+	DriftIgnoreRules []DriftIgnoreRule `json:"drift_ignore_rules,omitempty" validate:"omitempty,max=50,dive"`
+	RunOptions
+}
+
+// RunOptions are the ansible-playbook invocation options. They are embedded in
+// both PlaybookRequest and Job so a retry reproduces the same command line.
+type RunOptions struct {
+	ExtraVars   map[string]interface{} `json:"extra_vars,omitempty" validate:"omitempty,dive,keys,identifier,endkeys"`
+	Tags        []string               `json:"tags,omitempty" validate:"omitempty,dive,ansibletag"`
+	SkipTags    []string               `json:"skip_tags,omitempty" validate:"omitempty,dive,ansibletag"`
+	CheckMode   bool                   `json:"check_mode,omitempty"`
+	Diff        bool                   `json:"diff,omitempty"`
+	Verbosity   int                    `json:"verbosity,omitempty" validate:"min=0,max=4"`
+	Forks       int                    `json:"forks,omitempty" validate:"omitempty,min=1,max=500"`
+	Become      bool                   `json:"become,omitempty"`
+	StartAtTask string                 `json:"start_at_task,omitempty" validate:"omitempty,max=256"`
 }
 
 // Job represents a playbook execution job.
 type Job struct {
-	ID            string                       `json:"id"`
-	Status        string                       `json:"status"`
-	StartTime     time.Time                    `json:"start_time"`
-	EndTime       time.Time                    `json:"end_time"`
-	Output        string                       `json:"output"`
-	Error         string                       `json:"error"`
-	RepositoryURL string                       `json:"repository_url"`
-	PlaybookPath  string                       `json:"playbook_path"`
-	RetryCount    int                          `json:"retry_count"`
-	TargetHosts   string                       `json:"target_hosts"`
-	Inventory     map[string]map[string]string `json:"inventory"`
+	ID            string    `json:"id"`
+	Status        string    `json:"status"`
+	StartTime     time.Time `json:"start_time"`
+	EndTime       time.Time `json:"end_time"`
+	Output        string    `json:"output"`
+	Error         string    `json:"error"`
+	RepositoryURL string    `json:"repository_url"`
+	PlaybookPath  string    `json:"playbook_path"`
+	Ref           string    `json:"ref,omitempty"`
+	// CommitSHA is the commit the job ran; set before execution (on retries) it pins the checkout
+	CommitSHA      string                       `json:"commit_sha,omitempty"`
+	RequestedBy    string                       `json:"requested_by,omitempty"`
+	RetryCount     int                          `json:"retry_count"`
+	RetryOf        string                       `json:"retry_of,omitempty"`
+	TargetHosts    string                       `json:"target_hosts"`
+	Inventory      map[string]map[string]string `json:"inventory"`
+	WorkerID       int                          `json:"worker_id,omitempty"`
+	TimeoutSeconds int                          `json:"timeout_seconds,omitempty"`
+	Environment    map[string]string            `json:"environment,omitempty"`
+	// HasSecrets records that the job was submitted with secrets, which are
+	// held in memory only; the job fails if they are gone when it starts
+	HasSecrets    bool           `json:"has_secrets,omitempty"`
+	DriftSchedule *DriftSchedule `json:"drift_schedule,omitempty"`
+	// RemediationPolicy is applied to the playbook's drift tracking once the job has run
+	RemediationPolicy string `json:"remediation_policy,omitempty"`
+	// RemediationLimits are applied to the playbook's drift tracking once the job has run
+	RemediationLimits *RemediationLimits `json:"remediation_limits,omitempty"`
+	// DriftIgnoreRules are applied to the playbook's drift tracking once the job has run
+	DriftIgnoreRules []DriftIgnoreRule `json:"drift_ignore_rules,omitempty"`
+	// RemediationOf is the approved drift remediation this job carries out
+	RemediationOf string `json:"remediation_of,omitempty"`
+	// DriftID is the drift entry a remediation job belongs to
+	DriftID string `json:"drift_id,omitempty"`
+	// InventoryFile is the inventory file the job ran against when none was
+	// supplied with the request: relative to the repository root, or absolute
+	// for an inventory on the server
+	InventoryFile string `json:"inventory_file,omitempty"`
+	RunOptions
+	Result *PlaybookResult `json:"result,omitempty"`
+}
+
+// PlaybookResult is the structured outcome of a playbook run, collected by the
+// ansible_api_json callback plugin
+type PlaybookResult struct {
+	Start           time.Time            `json:"start"`
+	End             time.Time            `json:"end"`
+	DurationSeconds float64              `json:"duration_seconds"`
+	Plays           []PlayResult         `json:"plays"`
+	Stats           map[string]HostStats `json:"stats"`
+	Totals          HostStats            `json:"totals"`
+}
+
+// PlayResult holds the tasks executed by a single play
+type PlayResult struct {
+	Name            string       `json:"name"`
+	ID              string       `json:"id"`
+	Start           time.Time    `json:"start"`
+	End             time.Time    `json:"end"`
+	DurationSeconds float64      `json:"duration_seconds"`
+	Tasks           []TaskResult `json:"tasks"`
+}
+
+// TaskResult holds the per-host outcome of a single task
+type TaskResult struct {
+	Name            string                    `json:"name"`
+	ID              string                    `json:"id"`
+	Action          string                    `json:"action"`
+	Handler         bool                      `json:"handler,omitempty"`
+	Start           time.Time                 `json:"start"`
+	End             time.Time                 `json:"end"`
+	DurationSeconds float64                   `json:"duration_seconds"`
+	Hosts           map[string]HostTaskResult `json:"hosts"`
+}
+
+// HostTaskResult is a task's result on one host. Status is one of ok,
+// changed, failed, ignored, skipped or unreachable.
+type HostTaskResult struct {
+	Status  string     `json:"status"`
+	Changed bool       `json:"changed"`
+	Msg     string     `json:"msg,omitempty"`
+	Path    string     `json:"path,omitempty"`
+	Diff    []TaskDiff `json:"diff,omitempty"`
+}
+
+// TaskDiff is one --diff entry reported by a module
+type TaskDiff struct {
+	BeforeHeader string `json:"before_header,omitempty"`
+	AfterHeader  string `json:"after_header,omitempty"`
+	Before       string `json:"before,omitempty"`
+	After        string `json:"after,omitempty"`
+	Prepared     string `json:"prepared,omitempty"`
+}
+
+// HostStats are the play recap counters for one host
+type HostStats struct {
+	Ok          int `json:"ok"`
+	Changed     int `json:"changed"`
+	Unreachable int `json:"unreachable"`
+	Failures    int `json:"failures"`
+	Skipped     int `json:"skipped"`
+	Rescued     int `json:"rescued"`
+	Ignored     int `json:"ignored"`
+}
+
+// JobStore persists jobs so that history survives service restarts.
+// Implementations store and return copies; callers mutate their own Job
+// values and call Save to publish the change.
+type JobStore interface {
+	Save(job *Job) error
+	Get(id string) (*Job, bool)
+	List() []*Job
+	Delete(id string) error
+}
+
+// FileJobStore is a JobStore keeping one JSON file per job in a directory,
+// with an in-memory index for reads
+type FileJobStore struct {
+	dir    string
+	mu     sync.RWMutex
+	jobs   map[string]*Job
+	logger zerolog.Logger
+}
+
+// JobJanitor periodically prunes finished jobs older than Config.RetentionHours
+type JobJanitor struct {
+	server   *Server
+	interval time.Duration
+	logger   zerolog.Logger
+}
+
+// CleanupReport describes the outcome of a job retention cleanup run
+type CleanupReport struct {
+	Cutoff   time.Time `json:"cutoff"`
+	DryRun   bool      `json:"dry_run"`
+	Removed  []string  `json:"removed"`
+	Retained int       `json:"retained"`
+}
+
+// TaskRegistry tracks cancel functions for in-flight jobs and drift remediations
+type TaskRegistry struct {
+	mu      sync.Mutex
+	running map[string]context.CancelCauseFunc
+	pending map[string]bool
+}
+
+// StreamLine is a single line of job output
+type StreamLine struct {
+	Stream string `json:"stream"`
+	Text   string `json:"text"`
+}
+
+// OutputStream buffers a job's output and fans it out to live subscribers
+type OutputStream struct {
+	mu          sync.Mutex
+	lines       []StreamLine
+	subscribers map[chan StreamLine]struct{}
+	done        chan struct{}
+	closed      bool
+	status      string
+}
+
+// StreamRegistry holds the output streams of queued, running and recently finished jobs
+type StreamRegistry struct {
+	mu      sync.Mutex
+	streams map[string]*OutputStream
+}
+
+// RepoCache keeps a bare mirror per repository URL and hands out per-run
+// worktrees, evicting the least recently used mirrors beyond its size limit
+type RepoCache struct {
+	mu       sync.Mutex
+	dir      string
+	maxBytes int64
+	mirrors  map[string]*repoMirror
+	logger   zerolog.Logger
+}
+
+// repoMirror is a single cached bare repository. mu serialises git
+// operations on the mirror; the bookkeeping fields are guarded by the cache lock.
+type repoMirror struct {
+	mu          sync.Mutex
+	key         string
+	path        string
+	url         string
+	sizeBytes   int64
+	lastUsed    time.Time
+	lastFetched time.Time
+	inUse       int
+	hits        int
+	fetches     int
+}
+
+// repoMirrorMeta is persisted inside each mirror so the cache survives restarts
+type repoMirrorMeta struct {
+	RepositoryURL string    `json:"repository_url"`
+	LastUsed      time.Time `json:"last_used"`
+	LastFetched   time.Time `json:"last_fetched"`
+}
+
+// RepoCacheEntry describes a cached mirror for the inspection endpoint
+type RepoCacheEntry struct {
+	RepositoryURL string    `json:"repository_url"`
+	Path          string    `json:"path"`
+	SizeBytes     int64     `json:"size_bytes"`
+	LastUsed      time.Time `json:"last_used"`
+	LastFetched   time.Time `json:"last_fetched"`
+	InUse         int       `json:"in_use"`
+	Hits          int       `json:"hits"`
+	Fetches       int       `json:"fetches"`
+}
+
+// RepoCacheStatus summarises the repository cache
+type RepoCacheStatus struct {
+	Enabled      bool             `json:"enabled"`
+	Dir          string           `json:"dir"`
+	MaxBytes     int64            `json:"max_bytes"`
+	TotalBytes   int64            `json:"total_bytes"`
+	Repositories []RepoCacheEntry `json:"repositories"`
 }
 
 type PlaybookState struct {
-	Repo                  string   `json:"repo"`
-	LastRun               string   `json:"last_run"`
-	LastHash              string   `json:"last_hash"`
-	LastStatus            string   `json:"last_status"`
-	LastCheckOutput       string   `json:"last_check_output"`
-	LastRemediation       string   `json:"last_remediation"`
-	LastRemediationStatus string   `json:"last_remediation_status"`
-	DriftDetected         bool     `json:"drift_detected"`
-	LastTargets           []string `json:"last_targets"`
-	PlaybookCommit        string   `json:"playbook_commit"`
-	TargetHosts           string   `json:"target_hosts"`
-}
-
-// StateFile represents the state of all playbooks
+	Playbook string `json:"playbook"`
+	Repo     string `json:"repo"`
+	// InventorySource is "repository" or "request:<hash>" for a request-provided inventory
+	InventorySource string `json:"inventory_source"`
+	// Inventory is the inventory the tracked run used, reused by checks and remediations
+	Inventory       *DriftInventory `json:"inventory,omitempty"`
+	Ref             string          `json:"ref,omitempty"`
+	LastRun         string          `json:"last_run"`
+	LastHash        string          `json:"last_hash"`
+	LastStatus      string          `json:"last_status"`
+	LastCheckOutput string          `json:"last_check_output"`
+	// LastChanges are the tasks the last check found would change
+	LastChanges           []DriftChange `json:"last_changes,omitempty"`
+	LastRemediation       string        `json:"last_remediation"`
+	LastRemediationStatus string        `json:"last_remediation_status"`
+	LastRemediationID     string        `json:"last_remediation_id,omitempty"`
+	DriftDetected         bool          `json:"drift_detected"`
+	LastTargets           []string      `json:"last_targets"`
+	PlaybookCommit        string        `json:"playbook_commit"`
+	TargetHosts           string        `json:"target_hosts"`
+	// RunOptions and Environment are those of the tracked run, reused by checks and remediations
+	RunOptions  RunOptions        `json:"run_options"`
+	Environment map[string]string `json:"environment,omitempty"`
+	// Schedule overrides the default drift schedule for this playbook
+	Schedule *DriftSchedule `json:"schedule,omitempty"`
+	// RemediationPolicy overrides the default remediation policy for this playbook
+	RemediationPolicy  string              `json:"remediation_policy,omitempty"`
+	PendingRemediation *PendingRemediation `json:"pending_remediation,omitempty"`
+	// IgnoreRules are added to the global drift ignore rules for this playbook
+	IgnoreRules []DriftIgnoreRule `json:"ignore_rules,omitempty"`
+	// RemediationLimits overrides the default remediation safeguards for this playbook
+	RemediationLimits *RemediationLimits `json:"remediation_limits,omitempty"`
+	// RecentRemediations are the times of automatic remediations within the last hour
+	RecentRemediations []string `json:"recent_remediations,omitempty"`
+	// DeferredHosts are drifted hosts left for a later remediation by max_hosts
+	DeferredHosts []string `json:"deferred_hosts,omitempty"`
+	// RemediationFailures counts consecutive failed automatic remediations
+	RemediationFailures int `json:"remediation_failures"`
+	// RemediationSuspended is set when RemediationFailures reaches the failure
+	// threshold; automatic remediation stays off until it is reset through the API
+	RemediationSuspended   bool   `json:"remediation_suspended"`
+	RemediationSuspendedAt string `json:"remediation_suspended_at,omitempty"`
+}
+
+// RemediationLimits bound automatic drift remediation of a playbook. Unset
+// fields fall back to the server defaults; -1 removes a limit.
+type RemediationLimits struct {
+	// MaxPerHour is how many remediations may start within any hour
+	MaxPerHour int `json:"max_per_hour,omitempty" validate:"omitempty,min=-1,max=3600"`
+	// MaxHosts is how many drifted hosts a remediation changes; the rest are
+	// remediated in later batches
+	MaxHosts int `json:"max_hosts,omitempty" validate:"omitempty,min=-1"`
+	// FailureThreshold is how many consecutive failed remediations suspend automatic remediation
+	FailureThreshold int `json:"failure_threshold,omitempty" validate:"omitempty,min=-1,max=100"`
+}
+
+// DriftInventory is the effective inventory of a tracked run. A File is
+// re-read from the checkout on every check, so dynamic inventory scripts and
+// plugin configurations are re-evaluated; Groups replays a request-provided
+// inventory as-is.
+type DriftInventory struct {
+	// File is relative to the repository root, or absolute for an inventory on the server
+	File   string                       `json:"file,omitempty"`
+	Groups map[string]map[string]string `json:"groups,omitempty"`
+}
+
+// PendingRemediation is detected drift awaiting an operator decision under
+// the manual remediation policy
+type PendingRemediation struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	// Commit is the commit the drift was detected against; an approved remediation runs it
+	Commit         string `json:"commit,omitempty"`
+	DetectedAt     string `json:"detected_at"`
+	LastDetectedAt string `json:"last_detected_at"`
+	DecidedBy      string `json:"decided_by,omitempty"`
+	DecidedAt      string `json:"decided_at,omitempty"`
+	Reason         string `json:"reason,omitempty"`
+	JobID          string `json:"job_id,omitempty"`
+}
+
+// DriftCheckResult is the outcome of a single drift check
+type DriftCheckResult struct {
+	Time          string        `json:"time"`
+	Trigger       string        `json:"trigger"`
+	Commit        string        `json:"commit,omitempty"`
+	Status        string        `json:"status"`
+	DriftDetected bool          `json:"drift_detected"`
+	Changes       []DriftChange `json:"changes,omitempty"`
+	Error         string        `json:"error,omitempty"`
+	// RemediationID and RemediationTime are set when the check ran a remediation
+	RemediationID   string `json:"remediation_id,omitempty"`
+	RemediationTime string `json:"remediation_time,omitempty"`
+	// RemediatedHosts and DeferredHosts split the drifted hosts when max_hosts limits a remediation
+	RemediatedHosts []string `json:"remediated_hosts,omitempty"`
+	DeferredHosts   []string `json:"deferred_hosts,omitempty"`
+	// Output is the check mode output; it is not kept in the history
+	Output string `json:"output,omitempty"`
+}
+
+// DriftChange is a task that would change a host, as reported by check mode
+type DriftChange struct {
+	Host   string `json:"host"`
+	Task   string `json:"task"`
+	Module string `json:"module,omitempty"`
+	// Path is the file or directory the task manages, when the module reports one
+	Path string `json:"path,omitempty"`
+	// Diff is a unified diff with line numbers rendered from Files
+	Diff string `json:"diff,omitempty"`
+	// Files holds the before/after content of each --diff entry, truncated to 16 KiB
+	Files []TaskDiff `json:"files,omitempty"`
+	// IgnoredBy names the ignore rule that suppressed this change
+	IgnoredBy string `json:"ignored_by,omitempty"`
+}
+
+// DriftIgnoreRule suppresses drift changes matching every criterion it sets.
+// Task, DiffLine and DiffMask are regular expressions, Host and Path are glob
+// patterns and Module matches the module name with or without its collection
+// prefix. DiffLine matches a change only if every added and removed line
+// matches. DiffMask matches a change whose removed and added lines only differ
+// in text the pattern masks: its capture groups, or the whole match when it
+// has none.
+type DriftIgnoreRule struct {
+	Name     string `json:"name" validate:"required,max=100"`
+	Task     string `json:"task,omitempty" validate:"omitempty,regexp"`
+	Module   string `json:"module,omitempty"`
+	Host     string `json:"host,omitempty" validate:"omitempty,glob"`
+	Path     string `json:"path,omitempty" validate:"omitempty,glob"`
+	DiffLine string `json:"diff_line,omitempty" validate:"omitempty,regexp"`
+	DiffMask string `json:"diff_mask,omitempty" validate:"omitempty,regexp"`
+}
+
+// compiledIgnoreRule is a DriftIgnoreRule with its expressions compiled
+type compiledIgnoreRule struct {
+	DriftIgnoreRule
+	task     *regexp.Regexp
+	diffLine *regexp.Regexp
+	diffMask *regexp.Regexp
+}
+
+// DriftHistory holds the most recent check results of a tracked playbook, newest first
+type DriftHistory struct {
+	ID       string             `json:"id"`
+	Playbook string             `json:"playbook"`
+	Checks   []DriftCheckResult `json:"checks"`
+}
+
+// DriftEntry describes a tracked playbook in API responses
+type DriftEntry struct {
+	ID string `json:"id"`
+	PlaybookState
+	NextCheck *time.Time `json:"next_check,omitempty"`
+	Checking  bool       `json:"checking"`
+}
+
+// JobRetryRequest is the optional body of a retry request. Jobs run with
+// secrets can only be retried with the secrets sent again.
+type JobRetryRequest struct {
+	Secrets map[string]string `json:"secrets" validate:"omitempty,dive,keys,identifier,endkeys"`
+}
+
+// RemediationDecisionRequest is the optional body of an approve or reject request
+type RemediationDecisionRequest struct {
+	Reason string `json:"reason" validate:"max=1024"`
+}
+
+// DriftSchedule controls when a tracked playbook is checked for drift. Unset
+// fields fall back to the server defaults; maintenance windows are added to
+// the default windows.
+type DriftSchedule struct {
+	// IntervalSeconds checks at a fixed interval; Cron takes precedence when both are set
+	IntervalSeconds int    `json:"interval_seconds,omitempty" validate:"omitempty,min=60"`
+	Cron            string `json:"cron,omitempty" validate:"omitempty,cron"`
+	// JitterSeconds delays each check by a random amount up to this value
+	JitterSeconds      int                 `json:"jitter_seconds,omitempty" validate:"omitempty,min=1,max=86400"`
+	MaintenanceWindows []MaintenanceWindow `json:"maintenance_windows,omitempty" validate:"omitempty,dive"`
+}
+
+// MaintenanceWindow is a recurring period that starts at every match of Cron
+// and lasts DurationMinutes
+type MaintenanceWindow struct {
+	Cron            string `json:"cron" validate:"required,cron"`
+	DurationMinutes int    `json:"duration_minutes" validate:"required,min=1,max=10080"`
+	// Suppress is "checks" (the default) to skip checks entirely or
+	// "remediation" to check and report drift without fixing it
+	Suppress string `json:"suppress,omitempty" validate:"omitempty,oneof=checks remediation"`
+}
+
+// StateFile represents the state of all tracked playbooks, keyed by drift entry ID
 type StateFile map[string]PlaybookState
 
+// DriftStateStore persists the drift state of tracked playbooks
+type DriftStateStore interface {
+	// Load returns a snapshot of every tracked playbook
+	Load() (StateFile, error)
+	// Update applies fn to the current state and persists the result as one
+	// transaction; nothing is written if fn returns an error
+	Update(fn func(state StateFile) error) error
+}
+
+// FileDriftStateStore is a DriftStateStore keeping a versioned JSON document
+// in a directory. Writes are atomic and serialised.
+type FileDriftStateStore struct {
+	path string
+	// legacyPath is the pre-versioning state file imported on first open
+	legacyPath string
+	mu         sync.Mutex
+	opened     bool
+	logger     zerolog.Logger
+}
+
+// driftStateDocument is the on-disk format of FileDriftStateStore
+type driftStateDocument struct {
+	SchemaVersion int       `json:"schema_version"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	Playbooks     StateFile `json:"playbooks"`
+}
+
 // DriftDetector handles drift detection operations
 type DriftDetector struct {
-	server    *Server
-	stateFile string
+	server *Server
+	store  DriftStateStore
+	logger zerolog.Logger
+	// defaults is the server-wide schedule, resolved when the detector starts
+	defaults DriftSchedule
+	// globalIgnoreRules are the drift ignore rules applied to every playbook
+	globalIgnoreRules []compiledIgnoreRule
+	// sem bounds concurrent checks
+	sem chan struct{}
+	// mu guards nextCheck, running and remediations
+	mu        sync.Mutex
+	nextCheck map[string]time.Time
+	running   map[string]bool
+	// remediations maps running automatic remediations to their drift entry ID
+	remediations map[string]string
+	// historyDir holds the check history of each playbook; empty disables history
+	historyDir string
+	historyMu  sync.Mutex
+}
+
+// Role is an RBAC role; each role includes the permissions of the roles below it
+type Role string
+
+const (
+	// RoleViewer may list and inspect jobs
+	RoleViewer Role = "viewer"
+	// RoleOperator may also run, retry and cancel jobs
+	RoleOperator Role = "operator"
+	// RoleAdmin may also manage workers and service data
+	RoleAdmin Role = "admin"
+)
+
+// APIKey is a static credential mapped to a named caller and role
+type APIKey struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+	Role Role   `json:"role"`
+}
+
+// RolePolicy restricts a role to matching repositories and playbooks.
+// Patterns are globs; an empty list allows everything.
+type RolePolicy struct {
+	Repositories []string `json:"repositories"`
+	Playbooks    []string `json:"playbooks"`
+}
+
+// Principal is an authenticated API caller
+type Principal struct {
+	Name   string `json:"name"`
+	Role   Role   `json:"role"`
+	Method string `json:"method"`
+}
+
+// Authenticator validates API keys and JWT bearer tokens and applies role policies
+type Authenticator struct {
+	disabled  bool
+	apiKeys   map[[32]byte]APIKey
+	jwtSecret []byte
+	policies  map[Role]RolePolicy
 	logger    zerolog.Logger
 }
 
+// authClaims are the JWT claims accepted for bearer tokens
+type authClaims struct {
+	Role Role `json:"role"`
+	jwt.RegisteredClaims
+}
+
 // ConfigManager handles configuration loading and management
 type ConfigManager struct {
 	logger zerolog.Logger
@@ -110,5 +666,184 @@ type RequestValidator struct {
 
 // JobProcessor handles job processing and execution
 type JobProcessor struct {
-	server *Server
+	server       *Server
+	mu           sync.Mutex
+	workers      map[int]*jobWorker
+	nextWorkerID int
+	busy         int
+	secrets      map[string]map[string]string
+}
+
+// jobWorker is a single goroutine pulling jobs from the server queue
+type jobWorker struct {
+	id   int
+	stop chan struct{}
+}
+
+// WorkerPoolStatus describes the current state of the job worker pool
+type WorkerPoolStatus struct {
+	Workers       int `json:"workers"`
+	Busy          int `json:"busy"`
+	QueueLength   int `json:"queue_length"`
+	QueueCapacity int `json:"queue_capacity"`
+}
+
+// WorkerPoolRequest represents a request to resize the job worker pool
+type WorkerPoolRequest struct {
+	Count int `json:"count" validate:"required,min=1,max=64"`
+}
+
+// ServerMetrics holds the metrics exposed on /metrics
+type ServerMetrics struct {
+	Registry            *metrics.Registry
+	JobsFinished        *metrics.CounterVec
+	JobDuration         *metrics.HistogramVec
+	GithubTokenDuration *metrics.HistogramVec
+	GithubTokenFailures *metrics.CounterVec
+	VaultFailures       *metrics.CounterVec
+	DriftChecks         *metrics.CounterVec
+	DriftDetected       *metrics.GaugeVec
+	DriftRemediations   *metrics.CounterVec
+}
+
+// NotificationEvent is a job or drift event published to the notifiers
+type NotificationEvent struct {
+	ID      string             `json:"id"`
+	Type    string             `json:"type"`
+	Time    time.Time          `json:"time"`
+	Summary string             `json:"summary"`
+	Job     *JobNotification   `json:"job,omitempty"`
+	Drift   *DriftNotification `json:"drift,omitempty"`
+}
+
+// JobNotification describes the finished job of a job event
+type JobNotification struct {
+	ID            string    `json:"id"`
+	Status        string    `json:"status"`
+	RepositoryURL string    `json:"repository_url"`
+	PlaybookPath  string    `json:"playbook_path"`
+	Ref           string    `json:"ref,omitempty"`
+	CommitSHA     string    `json:"commit_sha,omitempty"`
+	TargetHosts   string    `json:"target_hosts,omitempty"`
+	RequestedBy   string    `json:"requested_by,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	StartTime     time.Time `json:"start_time"`
+	EndTime       time.Time `json:"end_time"`
+	RemediationOf string    `json:"remediation_of,omitempty"`
+	DriftID       string    `json:"drift_id,omitempty"`
+	// Recap holds the play recap counters per host, when the run produced them
+	Recap map[string]HostStats `json:"recap,omitempty"`
+}
+
+// DriftNotification describes the drift entry and check of a drift event
+type DriftNotification struct {
+	ID            string `json:"id"`
+	Playbook      string `json:"playbook"`
+	Repo          string `json:"repo"`
+	Ref           string `json:"ref,omitempty"`
+	Commit        string `json:"commit,omitempty"`
+	TargetHosts   string `json:"target_hosts,omitempty"`
+	Status        string `json:"status"`
+	Trigger       string `json:"trigger,omitempty"`
+	RemediationID string `json:"remediation_id,omitempty"`
+	// Cycle is the drift cycle of a scheduled check
+	Cycle string `json:"cycle,omitempty"`
+	// Changes are the changes that count as drift, without their file contents
+	Changes []DriftChange `json:"changes,omitempty"`
+}
+
+// Notifier delivers notification events to one kind of destination.
+// Notify must not block on delivery.
+type Notifier interface {
+	Notify(event NotificationEvent)
+}
+
+// DriftCycleNotifier is a Notifier that is also told when every check of a
+// drift cycle, one scheduler pass, has finished
+type DriftCycleNotifier interface {
+	Notifier
+	DriftCycleFinished(cycle string)
+}
+
+// Notifications fans events out to every configured notifier
+type Notifications struct {
+	notifiers []Notifier
+	logger    zerolog.Logger
+}
+
+// WebhookTarget is an outbound webhook subscribed to notification events
+type WebhookTarget struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Format is json (default), slack or teams
+	Format string `json:"format,omitempty"`
+	// Secret signs each payload with HMAC-SHA256 when set
+	Secret string `json:"secret,omitempty"`
+	// Events are the event types sent to the target; empty means all
+	Events []string `json:"events,omitempty"`
+}
+
+// WebhookDelivery is one payload sent, or being sent, to a webhook target
+type WebhookDelivery struct {
+	ID      string    `json:"id"`
+	Target  string    `json:"target"`
+	Format  string    `json:"format"`
+	Event   string    `json:"event"`
+	EventID string    `json:"event_id"`
+	Status  string    `json:"status"`
+	Created time.Time `json:"created"`
+	// RepositoryURL and PlaybookPath are those of the event's job or drift
+	// entry; role allow-lists apply to them
+	RepositoryURL string `json:"repository_url,omitempty"`
+	PlaybookPath  string `json:"playbook_path,omitempty"`
+	// RedeliveryOf is the delivery this one resends
+	RedeliveryOf string           `json:"redelivery_of,omitempty"`
+	Attempts     []WebhookAttempt `json:"attempts"`
+	Payload      json.RawMessage  `json:"payload"`
+}
+
+// WebhookAttempt is a single HTTP request of a delivery
+type WebhookAttempt struct {
+	Time       time.Time `json:"time"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+	// Response is the start of the response body
+	Response string `json:"response,omitempty"`
+}
+
+// WebhookNotifier sends events to webhook targets and records every delivery
+// in its directory
+type WebhookNotifier struct {
+	dir        string
+	targets    []WebhookTarget
+	client     *http.Client
+	mu         sync.Mutex
+	deliveries map[string]*WebhookDelivery
+	logger     zerolog.Logger
+}
+
+// EmailRecipient is an email address subscribed to notification events
+type EmailRecipient struct {
+	Address string `json:"address"`
+	// Events are the event types sent to the recipient; empty means all
+	Events []string `json:"events,omitempty"`
+	// Digest collects the drift events of a drift cycle into a single email
+	Digest bool `json:"digest,omitempty"`
+}
+
+// EmailNotifier sends events by email over SMTP
+type EmailNotifier struct {
+	addr       string
+	from       mail.Address
+	auth       smtp.Auth
+	recipients []EmailRecipient
+	subject    *template.Template
+	body       *template.Template
+	// sendMail is smtp.SendMail
+	sendMail func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error
+	mu       sync.Mutex
+	// digests holds the drift events of open drift cycles per recipient address
+	digests map[string]map[string][]NotificationEvent
+	logger  zerolog.Logger
 }
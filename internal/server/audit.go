@@ -0,0 +1,204 @@
+package server
+
+import (
+	"ansible-api/internal/audit"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultAuditQueryLimit = 100
+	maxAuditQueryLimit     = 1000
+)
+
+// sensitiveKeyRegex matches variable names whose values are redacted from audit payloads
+var sensitiveKeyRegex = regexp.MustCompile(`(?i)pass|secret|token|key|credential`)
+
+// recordAudit appends an event to the audit log, attributing it to the
+// authenticated caller when the event comes from an API request
+func (s *Server) recordAudit(c *gin.Context, event audit.Event) {
+	if s.Audit == nil {
+		return
+	}
+
+	if c != nil {
+		caller := principal(c)
+		if event.Actor == "" {
+			event.Actor = caller.Name
+		}
+		event.Role = string(caller.Role)
+		event.AuthMethod = caller.Method
+		event.RemoteAddr = c.ClientIP()
+	}
+
+	if err := s.Audit.Append(event); err != nil {
+		s.Logger.Error().
+			Err(err).
+			Str("action", event.Action).
+			Str("job_id", event.JobID).
+			Msg("Failed to write audit event")
+	}
+}
+
+// recordJobFinished records the final outcome of a job in the audit log and
+// metrics, and closes the approved remediation the job carried out
+func (s *Server) recordJobFinished(job *Job) {
+	s.JobMutex.RLock()
+	event := audit.Event{
+		Action:        "job.finished",
+		Actor:         job.RequestedBy,
+		JobID:         job.ID,
+		RepositoryURL: job.RepositoryURL,
+		PlaybookPath:  job.PlaybookPath,
+		Ref:           job.Ref,
+		CommitSHA:     job.CommitSHA,
+		TargetHosts:   job.TargetHosts,
+		Status:        job.Status,
+		Detail:        job.Error,
+	}
+	notification, notify := jobNotificationEvent(job)
+	finished := *job
+	s.JobMutex.RUnlock()
+
+	if s.Drift != nil {
+		if err := s.Drift.RemediationJobFinished(&finished); err != nil {
+			s.Logger.Error().Err(err).Str("job_id", finished.ID).Msg("Failed to close approved remediation")
+		}
+	}
+
+	if s.Metrics != nil {
+		s.Metrics.JobsFinished.Inc(event.Status)
+	}
+
+	if event.Actor == "" {
+		event.Actor = "system"
+	}
+	s.recordAudit(nil, event)
+
+	if notify {
+		s.notify(notification)
+	}
+}
+
+// jobAuditEvent describes a job for an audit event
+func jobAuditEvent(action string, job *Job) audit.Event {
+	return audit.Event{
+		Action:        action,
+		JobID:         job.ID,
+		RepositoryURL: job.RepositoryURL,
+		PlaybookPath:  job.PlaybookPath,
+		Ref:           job.Ref,
+		CommitSHA:     job.CommitSHA,
+		TargetHosts:   job.TargetHosts,
+		Status:        job.Status,
+	}
+}
+
+// auditRequestPayload returns the request as recorded in the audit log.
+// Secret values and variables with sensitive-looking names are redacted.
+func auditRequestPayload(req *PlaybookRequest) map[string]interface{} {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return nil
+	}
+
+	if secrets, ok := payload["secrets"].(map[string]interface{}); ok {
+		for name := range secrets {
+			secrets[name] = redactedValue
+		}
+	}
+	for _, field := range []string{"environment", "extra_vars"} {
+		if vars, ok := payload[field].(map[string]interface{}); ok {
+			redactSensitiveKeys(vars)
+		}
+	}
+	if inventory, ok := payload["inventory"].(map[string]interface{}); ok {
+		for _, hosts := range inventory {
+			if vars, ok := hosts.(map[string]interface{}); ok {
+				redactSensitiveKeys(vars)
+			}
+		}
+	}
+
+	return payload
+}
+
+// redactSensitiveKeys masks values whose keys look like credentials, recursively
+func redactSensitiveKeys(vars map[string]interface{}) {
+	for key, value := range vars {
+		if sensitiveKeyRegex.MatchString(key) {
+			vars[key] = redactedValue
+			continue
+		}
+		if nested, ok := value.(map[string]interface{}); ok {
+			redactSensitiveKeys(nested)
+		}
+	}
+}
+
+func (s *Server) handleAudit(c *gin.Context) {
+	reqLogger := s.Logger.With().
+		Str("endpoint", "/api/audit").
+		Str("remote_addr", c.ClientIP()).
+		Logger()
+
+	query := audit.Query{
+		Actor:  c.Query("actor"),
+		Action: c.Query("action"),
+		JobID:  c.Query("job_id"),
+		Limit:  defaultAuditQueryLimit,
+	}
+
+	for param, target := range map[string]*time.Time{"since": &query.Since, "until": &query.Until} {
+		if value := c.Query(param); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				c.JSON(400, gin.H{"error": fmt.Sprintf("invalid %s: expected RFC3339 time", param)})
+				return
+			}
+			*target = parsed
+		}
+	}
+
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxAuditQueryLimit {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxAuditQueryLimit)})
+			return
+		}
+		query.Limit = limit
+	}
+
+	events, err := s.Audit.Query(query)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Failed to query audit log")
+		c.JSON(500, gin.H{"error": "Failed to query audit log"})
+		return
+	}
+	if events == nil {
+		events = []audit.Event{}
+	}
+
+	response := gin.H{"events": events}
+	if c.Query("verify") == "true" {
+		verification, err := s.Audit.Verify()
+		if err != nil {
+			reqLogger.Error().Err(err).Msg("Failed to verify audit log")
+			c.JSON(500, gin.H{"error": "Failed to verify audit log"})
+			return
+		}
+		response["verification"] = verification
+	}
+
+	reqLogger.Debug().Int("events", len(events)).Msg("Audit log queried")
+	c.JSON(200, response)
+}
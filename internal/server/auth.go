@@ -0,0 +1,329 @@
+package server
+
+import (
+	"ansible-api/internal/audit"
+	"ansible-api/internal/vault"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// authVaultPath holds API keys, the JWT signing secret and role policies
+	authVaultPath = "ansible/auth"
+	// principalContextKey is where the authenticated caller is stored on the gin context
+	principalContextKey = "principal"
+)
+
+// roleRank orders roles so each one includes the permissions of those below it
+var roleRank = map[Role]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// NewAuthenticator loads authentication settings from Vault, falling back to
+// the AUTH_* environment variables for anything Vault does not provide
+func NewAuthenticator(vaultClient *vault.VaultClient) (*Authenticator, error) {
+	a := &Authenticator{
+		apiKeys:  make(map[[32]byte]APIKey),
+		policies: make(map[Role]RolePolicy),
+		logger:   log.With().Str("component", "auth").Logger(),
+	}
+
+	settings := map[string]interface{}{}
+	if vaultClient != nil {
+		secret, err := vaultClient.GetSecret(authVaultPath)
+		if err != nil {
+			a.logger.Info().Err(err).Msg("Auth configuration not found in Vault")
+		} else {
+			settings = secret
+		}
+	}
+
+	envVars := map[string]string{
+		"disabled":   "AUTH_DISABLED",
+		"jwt_secret": "AUTH_JWT_SECRET",
+		"api_keys":   "AUTH_API_KEYS",
+		"roles":      "AUTH_ROLES",
+	}
+	for key, env := range envVars {
+		if _, ok := settings[key]; !ok {
+			if value := os.Getenv(env); value != "" {
+				settings[key] = value
+			}
+		}
+	}
+
+	if err := a.configure(settings); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case a.disabled:
+		a.logger.Warn().Msg("API authentication is disabled, every request is treated as admin")
+	case len(a.apiKeys) == 0 && len(a.jwtSecret) == 0:
+		a.logger.Warn().Msg("No API keys or JWT secret configured, all authenticated endpoints will reject requests")
+	default:
+		a.logger.Info().
+			Int("api_keys", len(a.apiKeys)).
+			Bool("jwt_enabled", len(a.jwtSecret) > 0).
+			Int("role_policies", len(a.policies)).
+			Msg("API authentication configured")
+	}
+
+	return a, nil
+}
+
+// configure applies settings read from Vault or the environment. Structured
+// values may be given either natively or as JSON strings.
+func (a *Authenticator) configure(settings map[string]interface{}) error {
+	if value, ok := settings["disabled"]; ok {
+		a.disabled = fmt.Sprint(value) == "true"
+	}
+
+	if value, ok := settings["jwt_secret"].(string); ok {
+		a.jwtSecret = []byte(value)
+	}
+
+	var keys []APIKey
+	if err := decodeAuthSetting(settings["api_keys"], &keys); err != nil {
+		return fmt.Errorf("invalid api_keys: %w", err)
+	}
+	for _, key := range keys {
+		if key.Key == "" || key.Name == "" {
+			return fmt.Errorf("invalid api_keys: every key needs a name and a key")
+		}
+		if _, ok := roleRank[key.Role]; !ok {
+			return fmt.Errorf("invalid api_keys: key %q has unknown role %q", key.Name, key.Role)
+		}
+		a.apiKeys[sha256.Sum256([]byte(key.Key))] = key
+	}
+
+	var policies map[Role]RolePolicy
+	if err := decodeAuthSetting(settings["roles"], &policies); err != nil {
+		return fmt.Errorf("invalid roles: %w", err)
+	}
+	for role, policy := range policies {
+		if _, ok := roleRank[role]; !ok {
+			return fmt.Errorf("invalid roles: unknown role %q", role)
+		}
+		for _, pattern := range append(append([]string{}, policy.Repositories...), policy.Playbooks...) {
+			if _, err := globRegexp(pattern); err != nil {
+				return fmt.Errorf("invalid roles: bad pattern %q for role %q: %w", pattern, role, err)
+			}
+		}
+		a.policies[role] = policy
+	}
+
+	return nil
+}
+
+// decodeAuthSetting decodes a Vault or environment value into out
+func decodeAuthSetting(value interface{}, out interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		if v == "" {
+			return nil
+		}
+		return json.Unmarshal([]byte(v), out)
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(data, out)
+	}
+}
+
+// Authenticate identifies the caller from an X-API-Key header or an
+// Authorization bearer token, which may be an API key or a signed JWT
+func (a *Authenticator) Authenticate(c *gin.Context) (*Principal, error) {
+	if a.disabled {
+		return &Principal{Name: "anonymous", Role: RoleAdmin, Method: "disabled"}, nil
+	}
+
+	credential := c.GetHeader("X-API-Key")
+	if credential == "" {
+		header := c.GetHeader("Authorization")
+		if !strings.HasPrefix(header, "Bearer ") {
+			return nil, fmt.Errorf("missing credentials")
+		}
+		credential = strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	}
+
+	if key, ok := a.apiKeys[sha256.Sum256([]byte(credential))]; ok {
+		// The map lookup is on a hash; compare again in constant time before trusting it
+		if subtle.ConstantTimeCompare([]byte(key.Key), []byte(credential)) == 1 {
+			return &Principal{Name: key.Name, Role: key.Role, Method: "api_key"}, nil
+		}
+	}
+
+	if strings.Count(credential, ".") == 2 && len(a.jwtSecret) > 0 {
+		return a.parseToken(credential)
+	}
+
+	return nil, fmt.Errorf("invalid credentials")
+}
+
+// parseToken validates an HMAC-signed JWT carrying a subject and a role
+func (a *Authenticator) parseToken(tokenString string) (*Principal, error) {
+	claims := &authClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return a.jwtSecret, nil
+	},
+		jwt.WithValidMethods([]string{"HS256", "HS384", "HS512"}),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("invalid token: missing subject")
+	}
+	if _, ok := roleRank[claims.Role]; !ok {
+		return nil, fmt.Errorf("invalid token: unknown role %q", claims.Role)
+	}
+
+	return &Principal{Name: claims.Subject, Role: claims.Role, Method: "jwt"}, nil
+}
+
+// Allows reports whether a principal's role may act on a repository and playbook.
+// An empty allow-list places no restriction. The playbook path is matched in
+// its cleaned form, the one that is joined to the checkout.
+func (a *Authenticator) Allows(principal *Principal, repositoryURL, playbookPath string) bool {
+	policy, ok := a.policies[principal.Role]
+	if !ok {
+		return true
+	}
+	if playbookPath != "" {
+		playbookPath = path.Clean(playbookPath)
+	}
+	return matchesAny(policy.Repositories, repositoryURL) && matchesAny(policy.Playbooks, playbookPath)
+}
+
+// matchesAny reports whether value matches one of the glob patterns, or the list is empty
+func matchesAny(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if re, err := globRegexp(pattern); err == nil && re.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// globRegexp compiles a glob where * matches any run of characters within a
+// path segment, ** matches across slashes and ? matches a single character
+// other than a slash
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	var expr strings.Builder
+	expr.WriteString("^")
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; {
+		case r == '*' && i+1 < len(runes) && runes[i+1] == '*':
+			expr.WriteString(".*")
+			i++
+		case r == '*':
+			expr.WriteString("[^/]*")
+		case r == '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
+
+// requireRole authenticates the request and rejects callers below the given role
+func (s *Server) requireRole(role Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		caller, err := s.Auth.Authenticate(c)
+		if err != nil {
+			s.Logger.Warn().
+				Err(err).
+				Str("path", c.FullPath()).
+				Str("remote_addr", c.ClientIP()).
+				Msg("Authentication failed")
+			s.recordAudit(nil, audit.Event{
+				Action:     "auth.denied",
+				Actor:      "anonymous",
+				RemoteAddr: c.ClientIP(),
+				Status:     "unauthorized",
+				Detail:     c.Request.Method + " " + c.Request.URL.Path + ": " + err.Error(),
+			})
+			c.Header("WWW-Authenticate", `Bearer realm="ansible-api"`)
+			c.AbortWithStatusJSON(401, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		if roleRank[caller.Role] < roleRank[role] {
+			s.Logger.Warn().
+				Str("principal", caller.Name).
+				Str("role", string(caller.Role)).
+				Str("required_role", string(role)).
+				Str("path", c.FullPath()).
+				Msg("Insufficient role for request")
+			c.Set(principalContextKey, caller)
+			s.recordAudit(c, audit.Event{
+				Action: "auth.denied",
+				Status: "forbidden",
+				Detail: c.Request.Method + " " + c.Request.URL.Path + ": requires " + string(role),
+			})
+			c.AbortWithStatusJSON(403, gin.H{"error": "Forbidden", "required_role": role})
+			return
+		}
+
+		c.Set(principalContextKey, caller)
+		c.Next()
+	}
+}
+
+// principal returns the caller authenticated by requireRole
+func principal(c *gin.Context) *Principal {
+	if value, ok := c.Get(principalContextKey); ok {
+		return value.(*Principal)
+	}
+	return &Principal{Name: "anonymous"}
+}
+
+// authorizeTarget rejects the request with 403 if the caller's role may not
+// act on the repository and playbook
+func (s *Server) authorizeTarget(c *gin.Context, repositoryURL, playbookPath string) bool {
+	caller := principal(c)
+	if s.Auth.Allows(caller, repositoryURL, playbookPath) {
+		return true
+	}
+
+	s.Logger.Warn().
+		Str("principal", caller.Name).
+		Str("role", string(caller.Role)).
+		Str("repository_url", repositoryURL).
+		Str("playbook_path", playbookPath).
+		Msg("Repository or playbook not allowed for role")
+	s.recordAudit(c, audit.Event{
+		Action:        "auth.denied",
+		RepositoryURL: repositoryURL,
+		PlaybookPath:  playbookPath,
+		Status:        "forbidden",
+		Detail:        c.Request.Method + " " + c.Request.URL.Path + ": not in role allow-list",
+	})
+	c.JSON(403, gin.H{"error": "Repository or playbook not allowed for your role"})
+	return false
+}
@@ -0,0 +1,153 @@
+# Aggregate callback used by ansible-api to collect structured playbook results.
+# It runs alongside the default stdout callback so human-readable output can
+# still be streamed, and writes one JSON document to $ANSIBLE_API_RESULT_FILE
+# when the playbook finishes.
+from __future__ import absolute_import, division, print_function
+__metaclass__ = type
+
+DOCUMENTATION = '''
+    name: ansible_api_json
+    type: aggregate
+    short_description: Write structured playbook results for ansible-api
+    description:
+      - Records plays, tasks, per-host results and recap stats and writes them
+        as JSON to the file named by the ANSIBLE_API_RESULT_FILE environment variable.
+'''
+
+import datetime
+import json
+import os
+
+from ansible.plugins.callback import CallbackBase
+
+
+def _now():
+    return datetime.datetime.now(datetime.timezone.utc).isoformat()
+
+
+def _text(value):
+    if value is None:
+        return ''
+    if isinstance(value, str):
+        return value
+    return json.dumps(value, indent=2, sort_keys=True, default=str)
+
+
+def _diffs(result):
+    diff = result.get('diff')
+    if not diff:
+        return []
+    if isinstance(diff, dict):
+        diff = [diff]
+    entries = []
+    for item in diff:
+        if not isinstance(item, dict):
+            continue
+        entries.append({
+            'before_header': _text(item.get('before_header')),
+            'after_header': _text(item.get('after_header')),
+            'before': _text(item.get('before')),
+            'after': _text(item.get('after')),
+            'prepared': _text(item.get('prepared')),
+        })
+    return entries
+
+
+class CallbackModule(CallbackBase):
+    CALLBACK_VERSION = 2.0
+    CALLBACK_TYPE = 'aggregate'
+    CALLBACK_NAME = 'ansible_api_json'
+    CALLBACK_NEEDS_ENABLED = True
+
+    def __init__(self, display=None):
+        super(CallbackModule, self).__init__(display)
+        self._path = os.environ.get('ANSIBLE_API_RESULT_FILE')
+        self._results = {'start': _now(), 'end': None, 'plays': [], 'stats': {}}
+
+    def _current_play(self):
+        if not self._results['plays']:
+            self._results['plays'].append({
+                'play': {'name': '', 'id': '', 'start': _now(), 'end': None},
+                'tasks': [],
+            })
+        return self._results['plays'][-1]
+
+    def _start_task(self, task, handler=False):
+        play = self._current_play()
+        now = _now()
+        if play['tasks'] and play['tasks'][-1]['task']['end'] is None:
+            play['tasks'][-1]['task']['end'] = now
+        play['tasks'].append({
+            'task': {
+                'name': task.get_name(),
+                'id': str(task._uuid),
+                'action': task.action,
+                'handler': handler,
+                'start': now,
+                'end': None,
+            },
+            'hosts': {},
+        })
+
+    def _find_task(self, task):
+        uuid = str(task._uuid)
+        for play in reversed(self._results['plays']):
+            for entry in reversed(play['tasks']):
+                if entry['task']['id'] == uuid:
+                    return entry
+        self._start_task(task)
+        return self._current_play()['tasks'][-1]
+
+    def _record(self, result, status):
+        res = result._result
+        entry = self._find_task(result._task)
+        entry['hosts'][result._host.get_name()] = {
+            'status': status,
+            'changed': bool(res.get('changed', False)),
+            'msg': _text(res.get('msg')),
+            'path': _text(res.get('dest') or res.get('path')),
+            'diff': _diffs(res),
+        }
+
+    def v2_playbook_on_play_start(self, play):
+        now = _now()
+        if self._results['plays']:
+            last = self._results['plays'][-1]
+            last['play']['end'] = last['play']['end'] or now
+        self._results['plays'].append({
+            'play': {'name': play.get_name(), 'id': str(play._uuid), 'start': now, 'end': None},
+            'tasks': [],
+        })
+
+    def v2_playbook_on_task_start(self, task, is_conditional):
+        self._start_task(task)
+
+    def v2_playbook_on_handler_task_start(self, task):
+        self._start_task(task, handler=True)
+
+    def v2_runner_on_ok(self, result, **kwargs):
+        self._record(result, 'changed' if result._result.get('changed', False) else 'ok')
+
+    def v2_runner_on_failed(self, result, ignore_errors=False, **kwargs):
+        self._record(result, 'ignored' if ignore_errors else 'failed')
+
+    def v2_runner_on_skipped(self, result, **kwargs):
+        self._record(result, 'skipped')
+
+    def v2_runner_on_unreachable(self, result, **kwargs):
+        self._record(result, 'unreachable')
+
+    def v2_playbook_on_stats(self, stats):
+        now = _now()
+        for play in self._results['plays']:
+            play['play']['end'] = play['play']['end'] or now
+            for entry in play['tasks']:
+                entry['task']['end'] = entry['task']['end'] or now
+        for host in sorted(stats.processed.keys()):
+            self._results['stats'][host] = stats.summarize(host)
+        self._results['end'] = now
+
+        if not self._path:
+            return
+        with open(self._path, 'w') as f:
+            json.dump(self._results, f, default=str)
@@ -1,11 +1,13 @@
 package server
 
 import (
+	"ansible-api/internal/audit"
 	"ansible-api/internal/githubapp"
+	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -13,33 +15,80 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog/log"
-	"gopkg.in/src-d/go-git.v4"
 )
 
 // NewDriftDetector creates a new drift detector
 func NewDriftDetector(server *Server) *DriftDetector {
-	return &DriftDetector{
-		server:    server,
-		stateFile: filepath.Join(os.TempDir(), "default_system_state.json"),
-		logger:    log.With().Str("component", "drift").Logger(),
+	d := &DriftDetector{
+		server:       server,
+		logger:       log.With().Str("component", "drift").Logger(),
+		nextCheck:    make(map[string]time.Time),
+		running:      make(map[string]bool),
+		remediations: make(map[string]string),
 	}
+
+	dataDir := defaultDataDir()
+	if server != nil && server.Config != nil && server.Config.DataDir != "" {
+		dataDir = server.Config.DataDir
+	}
+	if server != nil && server.DriftState != nil {
+		d.store = server.DriftState
+	} else {
+		d.store = NewFileDriftStateStore(filepath.Join(dataDir, "drift"))
+	}
+
+	concurrency := 1
+	if server != nil && server.Config != nil {
+		config := server.Config
+		defaults, err := defaultDriftSchedule(config)
+		if err != nil {
+			d.logger.Error().Err(err).Msg("Invalid drift schedule configuration, using the default interval only")
+			defaults = DriftSchedule{IntervalSeconds: config.DriftIntervalSeconds, JitterSeconds: config.DriftJitterSeconds}
+		}
+		d.defaults = defaults
+
+		rules, err := defaultIgnoreRules(config)
+		if err != nil {
+			d.logger.Error().Err(err).Msg("Invalid drift ignore rules configuration, using the built-in rules only")
+			rules, _ = defaultIgnoreRules(&Config{DriftIgnoreDynamicContent: config.DriftIgnoreDynamicContent})
+		}
+		d.globalIgnoreRules = rules
+
+		if config.DriftConcurrency > 1 {
+			concurrency = config.DriftConcurrency
+		}
+		if config.DataDir != "" {
+			d.historyDir = filepath.Join(config.DataDir, "drift", "history")
+		}
+	}
+	d.sem = make(chan struct{}, concurrency)
+
+	return d
 }
 
 // Start begins the drift detection process
 func (d *DriftDetector) Start() {
+	d.logger.Info().
+		Int("interval_seconds", d.defaults.IntervalSeconds).
+		Str("cron", d.defaults.Cron).
+		Int("jitter_seconds", d.defaults.JitterSeconds).
+		Int("maintenance_windows", len(d.defaults.MaintenanceWindows)).
+		Int("concurrency", cap(d.sem)).
+		Msg("Drift detection scheduled")
+
 	go d.run()
 }
 
-// run executes drift detection in a loop
+// run starts due drift checks in a loop
 func (d *DriftDetector) run() {
-	// Run first detection immediately
+	// Every playbook is due on the first pass
 	d.detect()
 
-	// Then run every 3 minutes
-	ticker := time.NewTicker(3 * time.Minute)
+	ticker := time.NewTicker(driftSchedulerTick)
 	defer ticker.Stop()
 
 	for range ticker.C {
@@ -47,39 +96,112 @@ func (d *DriftDetector) run() {
 	}
 }
 
-// detect performs drift detection on all playbooks
+// detect starts a check for every playbook whose next check is due. Checks
+// run in the background, at most DriftConcurrency at a time.
 func (d *DriftDetector) detect() {
-	d.logger.Info().Msg("Starting drift detection")
-
-	state, err := d.loadState()
+	state, err := d.store.Load()
 	if err != nil {
-		d.logger.Error().Err(err).Msg("Failed to load state file")
+		d.logger.Error().Err(err).Msg("Failed to load drift state")
 		return
 	}
 
-	d.logger.Info().Int("playbook_count", len(state)).Msg("Loaded playbooks from state file")
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
 
-	changed := false
-	for logicalPath, playbookState := range state {
-		if d.checkPlaybookDrift(logicalPath, &playbookState) {
-			state[logicalPath] = playbookState
-			changed = true
+	// Forget playbooks that are no longer tracked
+	for id := range d.nextCheck {
+		if _, ok := state[id]; !ok {
+			delete(d.nextCheck, id)
 		}
 	}
 
-	if changed {
-		if err := d.saveState(state); err != nil {
-			d.logger.Error().Err(err).Msg("Failed to save state file")
+	// The checks started by this pass form a drift cycle; notifiers are told
+	// when all of them have finished
+	cycle := fmt.Sprintf("cycle-%d", now.UnixNano())
+	var checks sync.WaitGroup
+
+	due := 0
+	for id, playbookState := range state {
+		if d.running[id] || now.Before(d.nextCheck[id]) {
+			continue
 		}
+		d.running[id] = true
+		due++
+		checks.Add(1)
+		go func() {
+			defer checks.Done()
+			d.runScheduledCheck(id, playbookState, driftTriggerSchedule, cycle)
+		}()
+	}
+
+	if due > 0 {
+		d.logger.Info().Int("playbook_count", len(state)).Int("due", due).Str("cycle", cycle).Msg("Starting drift detection")
+		go func() {
+			checks.Wait()
+			d.server.driftCycleFinished(cycle)
+		}()
+	}
+}
+
+// runScheduledCheck checks one playbook, honouring its maintenance windows,
+// and schedules its next check. Checks requested through the API run even
+// while a window suppresses checks, but still do not remediate. cycle is the
+// drift cycle a scheduled check belongs to.
+func (d *DriftDetector) runScheduledCheck(id string, playbookState PlaybookState, trigger, cycle string) {
+	d.sem <- struct{}{}
+	schedule := d.effectiveSchedule(playbookState)
+
+	defer func() {
+		<-d.sem
+		next := d.nextDriftCheck(schedule, time.Now())
+		d.mu.Lock()
+		d.nextCheck[id] = next
+		delete(d.running, id)
+		d.mu.Unlock()
+		d.logger.Debug().Str("drift_id", id).Time("next_check", next).Msg("Next drift check scheduled")
+	}()
+
+	suppressed := activeMaintenance(schedule.MaintenanceWindows, time.Now())
+	if suppressed == suppressChecks && trigger == driftTriggerSchedule {
+		d.logger.Info().Str("drift_id", id).Str("playbook", playbookState.Playbook).Msg("Drift check skipped - maintenance window open")
+		return
 	}
+
+	d.checkPlaybookDrift(id, playbookState, suppressed != "", trigger, cycle)
+}
+
+// storePlaybookState applies the result of a check to the current state of
+// its entry. apply only sets the fields a check owns, so remediation
+// decisions, job runs and resets made while the check was running are kept.
+// Entries removed while the check was running are not recreated.
+func (d *DriftDetector) storePlaybookState(id string, apply func(current *PlaybookState)) error {
+	return d.store.Update(func(state StateFile) error {
+		current, ok := state[id]
+		if !ok {
+			d.logger.Info().Str("drift_id", id).Msg("Playbook removed during drift check, discarding result")
+			return nil
+		}
+
+		apply(&current)
+		state[id] = current
+		return nil
+	})
 }
 
-// checkPlaybookDrift checks for drift in a single playbook
-func (d *DriftDetector) checkPlaybookDrift(logicalPath string, playbookState *PlaybookState) bool {
-	d.logger.Info().Str("playbook", logicalPath).Msg("Checking playbook for drift")
+// checkPlaybookDrift checks for drift in a single playbook and records the
+// result. Detected drift is remediated according to the playbook's
+// remediation policy, unless a maintenance window suppresses remediation.
+// playbookState is the entry as it was when the check started.
+func (d *DriftDetector) checkPlaybookDrift(id string, playbookState PlaybookState, remediationSuppressed bool, trigger, cycle string) {
+	logicalPath := playbookState.Playbook
+	d.logger.Info().Str("drift_id", id).Str("playbook", logicalPath).Msg("Checking playbook for drift")
+
+	policy := d.remediationPolicy(playbookState)
+	limits := d.remediationLimits(playbookState)
 
 	// Get current commit hash
-	currentCommitHash, err := d.getRemoteCommitHash(playbookState.Repo, "main")
+	currentCommitHash, err := d.getRemoteCommitHash(playbookState.Repo, playbookState.Ref)
 	if err != nil {
 		d.logger.Warn().Str("repo", playbookState.Repo).Err(err).Msg("Failed to get remote commit hash")
 		currentCommitHash = ""
@@ -97,27 +219,27 @@ func (d *DriftDetector) checkPlaybookDrift(logicalPath string, playbookState *Pl
 				Str("new_commit", currentCommitHash).
 				Msg("Repository changed - running drift check")
 		} else {
-			// Check if we should skip drift checks when repo hasn't changed
-			if d.server.Config.DriftCheckOnlyOnRepoChange {
+			// Check if we should skip drift checks when repo hasn't changed;
+			// checks requested through the API, and checks with a remediation
+			// still outstanding, always run
+			if d.server.Config.DriftCheckOnlyOnRepoChange && trigger == driftTriggerSchedule && !remediationOutstanding(playbookState) {
 				d.logger.Debug().
 					Str("playbook", logicalPath).
 					Str("commit", currentCommitHash).
 					Msg("Repository unchanged - skipping drift check for performance")
 				// Repository hasn't changed, assume no drift
-				*playbookState = PlaybookState{
-					Repo:                  playbookState.Repo,
-					LastRun:               time.Now().UTC().Format(time.RFC3339),
-					LastHash:              playbookState.LastHash,
-					LastStatus:            "ok",
-					LastRemediation:       playbookState.LastRemediation,
-					LastRemediationStatus: "ok",
-					DriftDetected:         false,
-					LastTargets:           playbookState.LastTargets,
-					PlaybookCommit:        currentCommitHash,
-					TargetHosts:           playbookState.TargetHosts,
+				err := d.storePlaybookState(id, func(current *PlaybookState) {
+					current.LastRun = time.Now().UTC().Format(time.RFC3339)
+					current.LastStatus = "ok"
+					current.DriftDetected = false
+					current.PlaybookCommit = currentCommitHash
+				})
+				if err != nil {
+					d.logger.Error().Err(err).Str("drift_id", id).Msg("Failed to save drift state")
 				}
+				d.server.recordDriftCheck(logicalPath, "skipped", "", false)
 				d.logger.Info().Str("playbook", logicalPath).Msg("Drift check completed - skipped (no repo changes)")
-				return true
+				return
 			} else {
 				d.logger.Debug().
 					Str("playbook", logicalPath).
@@ -127,80 +249,237 @@ func (d *DriftDetector) checkPlaybookDrift(logicalPath string, playbookState *Pl
 		}
 	}
 
-	// Run drift check only if repository changed or it's the first run
-	driftDetected, remediationStatus, remediationTime := d.runDriftCheck(logicalPath, playbookState)
+	// Run drift check only if repository changed or it's the first run.
+	// Automatic remediation is held back while a safeguard blocks it.
+	remediate := policy == remediationAuto && !remediationSuppressed
+	blocked := ""
+	if remediate {
+		blocked = remediationBlocked(playbookState, limits, time.Now())
+		remediate = blocked == ""
+	}
+	result := d.runDriftCheck(id, logicalPath, &playbookState, currentCommitHash, remediate, limits.MaxHosts)
+	result.Time = time.Now().UTC().Format(time.RFC3339)
+	result.Trigger = trigger
+	result.Commit = currentCommitHash
+	driftDetected, remediationStatus, remediationTime, remediationID := result.DriftDetected, result.Status, result.RemediationTime, result.RemediationID
+
+	// Drift that was not remediated automatically is reported according to the policy
+	switch {
+	case driftDetected && remediationID == "" && policy == remediationAuto && blocked != "":
+		remediationStatus = blocked
+		d.logger.Warn().
+			Str("drift_id", id).
+			Str("playbook", logicalPath).
+			Str("status", blocked).
+			Int("recent_remediations", len(recentRemediations(playbookState.RecentRemediations, time.Now()))).
+			Msg("Drift detected - automatic remediation held back by safeguards")
+	case driftDetected && remediationID == "" && policy == remediationAuto:
+		remediationStatus = "suppressed"
+	}
 
-	// Update playbook state
+	// Record the check on the entry as it is now. The pending remediation is
+	// updated from its current decision: manual drift opens or keeps one, and
+	// a pending remediation is dropped once the drift is gone.
 	hash, _ := d.fileHash(filepath.Join(os.TempDir(), logicalPath))
-	*playbookState = PlaybookState{
-		Repo:                  playbookState.Repo,
-		LastRun:               time.Now().UTC().Format(time.RFC3339),
-		LastHash:              hash,
-		LastStatus:            remediationStatus,
-		LastRemediation:       remediationTime,
-		LastRemediationStatus: remediationStatus,
-		DriftDetected:         driftDetected,
-		LastTargets:           []string{},
-		PlaybookCommit:        currentCommitHash,
-		TargetHosts:           playbookState.TargetHosts,
+	var (
+		pending  *PendingRemediation
+		tripped  bool
+		failures int
+	)
+	err = d.storePlaybookState(id, func(current *PlaybookState) {
+		now := time.Now()
+		pending = current.PendingRemediation
+		switch {
+		case driftDetected && remediationID == "" && policy == remediationManual:
+			pending = recordPendingRemediation(pending, currentCommitHash)
+			remediationStatus = pendingRemediationStatus(pending)
+		case !driftDetected && remediationStatus == "ok" && pending != nil && pending.Status != remediationApproved:
+			pending = nil
+		}
+
+		current.PendingRemediation = pending
+		current.LastRun = now.UTC().Format(time.RFC3339)
+		current.LastHash = hash
+		current.LastStatus = remediationStatus
+		current.LastCheckOutput = result.Output
+		current.LastChanges = result.Changes
+		current.DriftDetected = driftDetected
+		current.LastTargets = []string{}
+		current.PlaybookCommit = currentCommitHash
+		current.DeferredHosts = result.DeferredHosts
+		current.RecentRemediations = recentRemediations(current.RecentRemediations, now)
+
+		// Automatic remediations count towards the rate limit and circuit breaker
+		if remediationID != "" {
+			current.LastRemediation = remediationTime
+			current.LastRemediationStatus = remediationStatus
+			current.LastRemediationID = remediationID
+			tripped = recordRemediationOutcome(current, limits, remediationStatus, now)
+			failures = current.RemediationFailures
+		}
+	})
+	if err != nil {
+		d.logger.Error().Err(err).Str("drift_id", id).Msg("Failed to save drift state")
+	}
+
+	if driftDetected && remediationID == "" && policy == remediationManual && pending != nil {
+		d.logger.Warn().
+			Str("playbook", logicalPath).
+			Str("remediation_id", pending.ID).
+			Str("status", pending.Status).
+			Msg("Drift awaiting remediation approval")
+	}
+	if tripped {
+		d.logger.Error().
+			Str("drift_id", id).
+			Str("playbook", logicalPath).
+			Int("failures", failures).
+			Msg("Automatic remediation suspended after consecutive failures")
+	}
+
+	result.Status = remediationStatus
+	d.appendHistory(id, logicalPath, result)
+
+	outcome := "no_drift"
+	switch {
+	case driftDetected:
+		outcome = "drift_detected"
+	case remediationStatus == "error":
+		outcome = "error"
+	}
+	d.server.recordDriftCheck(logicalPath, outcome, remediationStatus, remediationID != "")
+
+	if remediationID != "" && d.server != nil {
+		d.server.recordAudit(nil, audit.Event{
+			Action:        "drift.remediation",
+			Actor:         "drift-detector",
+			JobID:         remediationID,
+			RepositoryURL: playbookState.Repo,
+			PlaybookPath:  logicalPath,
+			Ref:           playbookState.Ref,
+			CommitSHA:     currentCommitHash,
+			TargetHosts:   playbookState.TargetHosts,
+			Status:        remediationStatus,
+			Detail:        id,
+		})
+	}
+	// Notify drift the last check did not report, and automatic remediation outcomes
+	notifyDrift := func(eventType string) {
+		event := driftNotificationEvent(eventType, id, playbookState, result)
+		event.Drift.Cycle = cycle
+		d.server.notify(event)
+	}
+	if driftDetected && driftChanged(playbookState, result.Changes) {
+		notifyDrift(eventDriftDetected)
+	}
+	switch {
+	case remediationID != "" && remediationStatus == "ok":
+		notifyDrift(eventDriftRemediated)
+	case remediationID != "" && remediationStatus == "error":
+		notifyDrift(eventDriftRemediationFailed)
+	}
+
+	if tripped && d.server != nil {
+		d.server.recordAudit(nil, audit.Event{
+			Action:        "drift.remediation.suspend",
+			Actor:         "drift-detector",
+			JobID:         remediationID,
+			RepositoryURL: playbookState.Repo,
+			PlaybookPath:  logicalPath,
+			Ref:           playbookState.Ref,
+			CommitSHA:     currentCommitHash,
+			TargetHosts:   playbookState.TargetHosts,
+			Status:        remediationSuspended,
+			Detail:        id,
+		})
 	}
 
 	d.logger.Info().
+		Str("drift_id", id).
 		Str("playbook", logicalPath).
 		Bool("drift_detected", driftDetected).
 		Str("status", remediationStatus).
 		Msg("Drift check completed")
-
-	return true
 }
 
 // runDriftCheck executes Ansible check mode and remediation if needed
-func (d *DriftDetector) runDriftCheck(logicalPath string, playbookState *PlaybookState) (bool, string, string) {
+func (d *DriftDetector) runDriftCheck(id, logicalPath string, playbookState *PlaybookState, commit string, remediate bool, maxHosts int) DriftCheckResult {
 	tmpDir, err := os.MkdirTemp("", "repo-drift-")
 	if err != nil {
 		d.logger.Error().Err(err).Msg("Failed to create temp directory")
-		return false, "error", ""
+		return DriftCheckResult{Status: "error", Error: err.Error()}
 	}
 	defer os.RemoveAll(tmpDir)
 
-	// Clone repository
-	if err := d.cloneRepository(playbookState.Repo, tmpDir); err != nil {
+	// Check out the commit the ref currently points at, or the ref itself if
+	// it could not be resolved remotely
+	revision := commit
+	if revision == "" {
+		revision = playbookState.Ref
+	}
+	release, err := d.cloneRepository(playbookState.Repo, tmpDir, revision)
+	if err != nil {
 		d.logger.Error().Err(err).Msg("Failed to clone repository")
-		return false, "error", ""
+		return DriftCheckResult{Status: "error", Error: err.Error()}
 	}
+	defer release()
 
 	// Find playbook and inventory files
 	playbookPath := filepath.Join(tmpDir, logicalPath)
-	inventoryPath, err := d.findInventoryFile(tmpDir)
+	inventoryPath, err := d.inventoryPath(tmpDir, playbookState.Inventory)
 	if err != nil {
-		d.logger.Error().Err(err).Msg("Failed to find inventory file")
-		return false, "error", ""
+		d.logger.Error().Err(err).Msg("Failed to prepare inventory")
+		return DriftCheckResult{Status: "error", Error: err.Error()}
 	}
 
 	// Run Ansible check mode
-	driftDetected, remediationStatus, remediationTime := d.runAnsibleCheck(playbookPath, inventoryPath, playbookState.TargetHosts)
-
-	return driftDetected, remediationStatus, remediationTime
+	return d.runAnsibleCheck(id, playbookPath, inventoryPath, playbookState.TargetHosts, playbookState.RunOptions, playbookState.Environment, d.ignoreRules(*playbookState), remediate, maxHosts)
 }
 
-// cloneRepository clones a repository using GitHub App authentication
-func (d *DriftDetector) cloneRepository(repoURL, tmpDir string) error {
+// cloneRepository checks out a revision (the default branch when empty) from
+// the repository cache using GitHub App authentication. The returned function
+// releases the checkout.
+func (d *DriftDetector) cloneRepository(repoURL, tmpDir, revision string) (func(), error) {
 	token, err := d.getGitHubToken()
 	if err != nil {
-		return fmt.Errorf("failed to get GitHub token: %w", err)
+		return nil, fmt.Errorf("failed to get GitHub token: %w", err)
 	}
 
 	repoPath := d.extractRepoPath(repoURL)
 	host := d.extractHost(repoURL)
 	cloneURL := githubapp.BuildCloneURL(token, repoPath, host)
 
-	d.logger.Info().Str("repo", repoURL).Msg("Cloning repository")
+	d.logger.Info().Str("repo", repoURL).Str("revision", revision).Msg("Checking out repository")
 
-	_, err = git.PlainClone(tmpDir, false, &git.CloneOptions{
-		URL: cloneURL,
-	})
+	_, release, err := d.server.RepoCache.Checkout(context.Background(), repoURL, cloneURL, revision, tmpDir)
+	return release, err
+}
 
-	return err
+// inventoryPath returns the inventory a check of a tracked run uses: the one
+// recorded from the run, or the repository's inventory file for entries
+// tracked before inventories were recorded
+func (d *DriftDetector) inventoryPath(tmpDir string, inventory *DriftInventory) (string, error) {
+	switch {
+	case inventory == nil:
+		return d.findInventoryFile(tmpDir)
+	case len(inventory.Groups) > 0:
+		// Written where the job wrote it, so inventory/group_vars still applies
+		inventoryPath := filepath.Join(tmpDir, "inventory", "hosts.ini")
+		if err := writeInventoryFile(inventoryPath, inventory.Groups); err != nil {
+			return "", fmt.Errorf("failed to write inventory: %w", err)
+		}
+		return inventoryPath, nil
+	case inventory.File != "":
+		inventoryPath := inventory.File
+		if !filepath.IsAbs(inventoryPath) {
+			inventoryPath = filepath.Join(tmpDir, filepath.Clean("/"+inventoryPath))
+		}
+		if _, err := os.Stat(inventoryPath); err != nil {
+			return "", fmt.Errorf("inventory %s used by the tracked run is not available: %w", inventory.File, err)
+		}
+		return inventoryPath, nil
+	}
+	return d.findInventoryFile(tmpDir)
 }
 
 // findInventoryFile locates the inventory file in the repository
@@ -220,14 +499,26 @@ func (d *DriftDetector) findInventoryFile(tmpDir string) (string, error) {
 	return "", fmt.Errorf("no inventory file found")
 }
 
-// runAnsibleCheck executes Ansible check mode and handles remediation
-func (d *DriftDetector) runAnsibleCheck(playbookPath, inventoryPath, targetHosts string) (bool, string, string) {
+// runAnsibleCheck executes Ansible check mode and handles remediation. The
+// check and the remediation run with the options and environment of the
+// tracked run. A positive maxHosts limits the remediation to that many of the
+// drifted hosts.
+func (d *DriftDetector) runAnsibleCheck(id, playbookPath, inventoryPath, targetHosts string, opts RunOptions, env map[string]string, rules []compiledIgnoreRule, remediate bool, maxHosts int) DriftCheckResult {
 	d.logger.Info().Str("playbook", playbookPath).Msg("Running Ansible check mode")
 
-	cmd := exec.Command("ansible-playbook", playbookPath, "--check", "--diff", "--inventory", inventoryPath)
+	checkOpts := opts
+	checkOpts.CheckMode = true
+	checkOpts.Diff = true
+	optionArgs, err := playbookOptionArgs(checkOpts)
+	if err != nil {
+		return DriftCheckResult{Status: "error", Error: err.Error()}
+	}
+
+	cmd := exec.Command("ansible-playbook", playbookPath, "--inventory", inventoryPath)
 	if targetHosts != "" {
 		cmd.Args = append(cmd.Args, "--limit", targetHosts)
 	}
+	cmd.Args = append(cmd.Args, optionArgs...)
 
 	// Set working directory to the cloned repository root
 	repoRoot := filepath.Dir(filepath.Dir(filepath.Dir(playbookPath))) // Go up from playbooks/webservers/deploy.yml to repo root
@@ -239,6 +530,7 @@ func (d *DriftDetector) runAnsibleCheck(playbookPath, inventoryPath, targetHosts
 		"ANSIBLE_HOST_KEY_CHECKING=False",
 		"ANSIBLE_ROLES_PATH="+rolesPath,
 	)
+	cmd.Env = append(cmd.Env, requestEnvironment(env)...)
 
 	// Pass SSH credentials from Vault via environment variables (same as processor.go)
 	if d.server != nil && d.server.VaultClient != nil {
@@ -255,44 +547,127 @@ func (d *DriftDetector) runAnsibleCheck(playbookPath, inventoryPath, targetHosts
 		}
 	}
 
+	// Collect per-task results, including --diff content, through the result callback
+	callbackDir, err := os.MkdirTemp("", "drift-result-")
+	if err != nil {
+		return DriftCheckResult{Status: "error", Error: err.Error()}
+	}
+	defer os.RemoveAll(callbackDir)
+	callbackEnv, resultFile, err := prepareResultCallback(callbackDir)
+	if err != nil {
+		return DriftCheckResult{Status: "error", Error: err.Error()}
+	}
+	cmd.Env = append(cmd.Env, callbackEnv...)
+
 	outputBytes, err := cmd.CombinedOutput()
 	output := string(outputBytes)
 
 	d.logAnsibleSummary(playbookPath, output)
 
+	result := DriftCheckResult{
+		Status: "ok",
+		Output: truncateDriftOutput(output),
+	}
+
 	if err != nil {
 		d.logger.Error().Str("playbook", playbookPath).Str("ansible_output", output).Err(err).Msg("Ansible check mode failed")
-		return false, "error", ""
+		result.Status = "error"
+		result.Error = err.Error()
+		return result
+	}
+
+	if playbookResult, loadErr := loadPlaybookResult(resultFile, func(s string) string { return s }); loadErr == nil {
+		result.Changes = driftChangesFromResult(playbookResult)
+	} else {
+		// Without the callback result fall back to reading the text output
+		d.logger.Warn().Err(loadErr).Str("playbook", playbookPath).Msg("Structured check results unavailable, parsing output")
+		result.Changes = parseDriftChanges(output)
 	}
 
-	// Check for changes
-	if strings.Contains(output, "changed=0") {
+	if len(result.Changes) == 0 {
 		d.logger.Info().Str("playbook", playbookPath).Msg("No drift detected")
-		return false, "ok", ""
+		return result
 	}
 
-	if strings.Contains(output, "changed=") && !strings.Contains(output, "changed=0") {
-		if d.areChangesIgnorable(output) {
-			d.logger.Info().Str("playbook", playbookPath).Msg("No drift - ignorable changes only")
-			return false, "ok", ""
+	remaining := applyIgnoreRules(result.Changes, rules)
+	for _, change := range result.Changes {
+		if change.IgnoredBy != "" {
+			d.logger.Info().
+				Str("playbook", playbookPath).
+				Str("host", change.Host).
+				Str("task", change.Task).
+				Str("rule", change.IgnoredBy).
+				Msg("Change ignored by rule")
 		}
+	}
+	if remaining == 0 {
+		d.logger.Info().Str("playbook", playbookPath).Int("ignored", len(result.Changes)).Msg("No drift - all changes ignored by rules")
+		return result
+	}
 
-		// Log the specific changes that triggered drift detection for debugging
-		d.logger.Warn().Str("playbook", playbookPath).Str("ansible_output", output).Msg("Drift detected - running remediation")
-		remediationStatus, remediationTime := d.remediateDrift(playbookPath, inventoryPath, targetHosts)
-		return true, remediationStatus, remediationTime
+	result.DriftDetected = true
+	if !remediate {
+		d.logger.Warn().Str("playbook", playbookPath).Int("changes", remaining).Msg("Drift detected - not remediating automatically")
+		result.Status = "drift"
+		return result
 	}
 
-	d.logger.Info().Str("playbook", playbookPath).Msg("No drift detected")
-	return false, "ok", ""
+	// Limit the blast radius to a batch of the drifted hosts; the others are
+	// remediated by later checks
+	limit := targetHosts
+	if maxHosts > 0 {
+		result.RemediatedHosts, result.DeferredHosts = remediationBatch(result.Changes, maxHosts)
+		if len(result.RemediatedHosts) == 0 {
+			d.logger.Warn().Str("playbook", playbookPath).Msg("Drift detected on unknown hosts - not remediating a limited batch")
+			result.Status = "drift"
+			return result
+		}
+		limit = strings.Join(result.RemediatedHosts, ",")
+	}
+
+	d.logger.Warn().
+		Str("playbook", playbookPath).
+		Int("changes", remaining).
+		Strs("hosts", result.RemediatedHosts).
+		Strs("deferred_hosts", result.DeferredHosts).
+		Msg("Drift detected - running remediation")
+	result.Status, result.RemediationTime, result.RemediationID = d.remediateDrift(id, playbookPath, inventoryPath, limit, opts, env)
+	return result
 }
 
-// remediateDrift runs Ansible to fix detected drift
-func (d *DriftDetector) remediateDrift(playbookPath, inventoryPath, targetHosts string) (string, string) {
+// remediateDrift runs Ansible to fix detected drift of the entry id. The run
+// is registered under a remediation ID so it can be cancelled through the job
+// cancel endpoint.
+func (d *DriftDetector) remediateDrift(id, playbookPath, inventoryPath, targetHosts string, opts RunOptions, env map[string]string) (string, string, string) {
+	remediationID := fmt.Sprintf("drift-%d", time.Now().UnixNano())
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	if d.server != nil && d.server.Tasks != nil {
+		d.server.Tasks.Begin(remediationID, cancel)
+		defer d.server.Tasks.End(remediationID)
+	}
+	d.mu.Lock()
+	d.remediations[remediationID] = id
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		delete(d.remediations, remediationID)
+		d.mu.Unlock()
+	}()
+
+	d.logger.Info().Str("playbook", playbookPath).Str("remediation_id", remediationID).Msg("Starting drift remediation")
+
+	optionArgs, err := playbookOptionArgs(opts)
+	if err != nil {
+		d.logger.Error().Str("playbook", playbookPath).Str("remediation_id", remediationID).Err(err).Msg("Invalid playbook options")
+		return "error", time.Now().UTC().Format(time.RFC3339), remediationID
+	}
+
 	cmd := exec.Command("ansible-playbook", playbookPath, "--inventory", inventoryPath)
 	if targetHosts != "" {
 		cmd.Args = append(cmd.Args, "--limit", targetHosts)
 	}
+	cmd.Args = append(cmd.Args, optionArgs...)
 
 	// Set working directory to the cloned repository root
 	repoRoot := filepath.Dir(filepath.Dir(filepath.Dir(playbookPath))) // Go up from playbooks/webservers/deploy.yml to repo root
@@ -304,6 +679,7 @@ func (d *DriftDetector) remediateDrift(playbookPath, inventoryPath, targetHosts
 		"ANSIBLE_HOST_KEY_CHECKING=False",
 		"ANSIBLE_ROLES_PATH="+rolesPath,
 	)
+	cmd.Env = append(cmd.Env, requestEnvironment(env)...)
 
 	// Pass SSH credentials from Vault via environment variables (same as processor.go)
 	if d.server != nil && d.server.VaultClient != nil {
@@ -320,29 +696,45 @@ func (d *DriftDetector) remediateDrift(playbookPath, inventoryPath, targetHosts
 		}
 	}
 
-	_, err := cmd.CombinedOutput()
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err = runProcess(ctx, cmd)
+	if errors.Is(context.Cause(ctx), errJobCancelled) {
+		d.logger.Warn().
+			Str("playbook", playbookPath).
+			Str("remediation_id", remediationID).
+			Str("ansible_output", output.String()).
+			Msg("Ansible remediation cancelled")
+		return "cancelled", time.Now().UTC().Format(time.RFC3339), remediationID
+	}
 	if err != nil {
-		d.logger.Error().Str("playbook", playbookPath).Err(err).Msg("Ansible remediation failed")
-		return "error", time.Now().UTC().Format(time.RFC3339)
+		d.logger.Error().Str("playbook", playbookPath).Str("remediation_id", remediationID).Err(err).Msg("Ansible remediation failed")
+		return "error", time.Now().UTC().Format(time.RFC3339), remediationID
 	}
 
-	d.logger.Info().Str("playbook", playbookPath).Msg("Ansible remediation completed successfully")
-	return "ok", time.Now().UTC().Format(time.RFC3339)
+	d.logger.Info().Str("playbook", playbookPath).Str("remediation_id", remediationID).Msg("Ansible remediation completed successfully")
+	return "ok", time.Now().UTC().Format(time.RFC3339), remediationID
 }
 
 // getGitHubToken retrieves a GitHub App installation token
 func (d *DriftDetector) getGitHubToken() (string, error) {
-	return (&githubapp.DefaultAuthenticator{}).GetInstallationToken(githubapp.AuthConfig{
-		AppID:          d.server.GithubAppID,
-		InstallationID: d.server.GithubInstallationID,
-		PrivateKey:     d.server.GithubPrivateKey,
-		APIBaseURL:     d.server.GithubAPIBaseURL,
-	})
+	return d.server.githubToken()
 }
 
-// getRemoteCommitHash gets the current commit hash from a remote repository
-func (d *DriftDetector) getRemoteCommitHash(repoURL, branch string) (string, error) {
-	d.logger.Debug().Str("repo", repoURL).Str("branch", branch).Msg("Getting remote commit hash")
+// getRemoteCommitHash resolves a branch, tag or commit to the commit it
+// currently points at in a remote repository. An empty ref means HEAD.
+func (d *DriftDetector) getRemoteCommitHash(repoURL, ref string) (string, error) {
+	// A full commit SHA is already pinned and cannot move
+	if fullCommitSHARegex.MatchString(ref) {
+		return strings.ToLower(ref), nil
+	}
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	d.logger.Debug().Str("repo", repoURL).Str("ref", ref).Msg("Getting remote commit hash")
 
 	token, err := d.getGitHubToken()
 	if err != nil {
@@ -360,7 +752,7 @@ func (d *DriftDetector) getRemoteCommitHash(repoURL, branch string) (string, err
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, "git", "ls-remote", "--heads", cloneURL, branch)
+	cmd := exec.CommandContext(ctx, "git", "ls-remote", cloneURL, ref)
 	output, err := cmd.Output()
 	if err != nil {
 		if ctx.Err() == context.DeadlineExceeded {
@@ -371,21 +763,24 @@ func (d *DriftDetector) getRemoteCommitHash(repoURL, branch string) (string, err
 		return "", fmt.Errorf("git ls-remote failed: %w", err)
 	}
 
-	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
-	if len(lines) == 0 {
-		d.logger.Error().Str("repo", repoURL).Msg("No output from git ls-remote")
-		return "", fmt.Errorf("no output from git ls-remote")
+	refs := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		parts := strings.Fields(line)
+		if len(parts) == 2 {
+			refs[parts[1]] = parts[0]
+		}
 	}
 
-	parts := strings.Fields(lines[0])
-	if len(parts) < 2 {
-		d.logger.Error().Str("repo", repoURL).Str("output", string(output)).Msg("Unexpected git ls-remote output format")
-		return "", fmt.Errorf("unexpected output format from git ls-remote")
+	// Prefer the peeled commit of an annotated tag, then tags, then branches
+	for _, name := range []string{"refs/tags/" + ref + "^{}", "refs/tags/" + ref, "refs/heads/" + ref, ref} {
+		if commitHash, ok := refs[name]; ok {
+			d.logger.Debug().Str("repo", repoURL).Str("ref", name).Str("commit", commitHash).Msg("Successfully retrieved remote commit hash")
+			return commitHash, nil
+		}
 	}
 
-	commitHash := parts[0]
-	d.logger.Debug().Str("repo", repoURL).Str("commit", commitHash).Msg("Successfully retrieved remote commit hash")
-	return commitHash, nil
+	d.logger.Error().Str("repo", repoURL).Str("ref", ref).Str("output", string(output)).Msg("Ref not found on remote")
+	return "", fmt.Errorf("ref %q not found on remote", ref)
 }
 
 // extractRepoPath extracts the repository path from a URL
@@ -426,40 +821,6 @@ func (d *DriftDetector) fileHash(filename string) (string, error) {
 	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
-// loadState loads the state file
-func (d *DriftDetector) loadState() (StateFile, error) {
-	state := make(StateFile)
-
-	f, err := os.Open(d.stateFile)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return state, nil
-		}
-		return nil, err
-	}
-	defer f.Close()
-
-	dec := json.NewDecoder(f)
-	if err := dec.Decode(&state); err != nil {
-		return nil, err
-	}
-
-	return state, nil
-}
-
-// saveState saves the state file
-func (d *DriftDetector) saveState(state StateFile) error {
-	f, err := os.Create(d.stateFile)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	enc := json.NewEncoder(f)
-	enc.SetIndent("", "  ")
-	return enc.Encode(state)
-}
-
 // logAnsibleSummary logs a clean summary of Ansible output
 func (d *DriftDetector) logAnsibleSummary(playbook, output string) {
 	lines := strings.Split(output, "\n")
@@ -486,117 +847,58 @@ func (d *DriftDetector) logAnsibleSummary(playbook, output string) {
 		Msg("Ansible check mode completed")
 }
 
-// areChangesIgnorable checks if all changes in Ansible output are ignorable
-func (d *DriftDetector) areChangesIgnorable(output string) bool {
-	// Patterns that indicate ignorable changes
-	ignorablePatterns := []string{
-		"atime", "mtime", "ctime",
-		"ansible_date_time", "generated by ansible",
-		"timestamp", "last_modified", "last_updated",
-		"access_time", "modification_time", "creation_time",
-		"ansible date and time", "date and time",
-		"iso8601", "utc", "gmt", "timezone",
-		"deployed:", "deployment time", "creation date",
-		"verify", "health_check", "check", "test", "ping", "curl", "wget",
-		"uri", "get_url", "stat", "find", "command", "shell",
-		"ansible_facts", "gather_facts", "setup",
-		"accessible", "deployment results", "display", "debug",
-		"create custom index page", "index page", "index.html",
-	}
-
-	// Comprehensive regex patterns for timestamp and dynamic content changes
-	dynamicContentRegexes := []*regexp.Regexp{
-		// ISO 8601 timestamps (most common)
-		regexp.MustCompile(`^\s*[+-].*\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(?:\.\d{3})?Z?`),
-		// Date formats
-		regexp.MustCompile(`^\s*[+-].*\d{4}-\d{2}-\d{2}\s+\d{2}:\d{2}:\d{2}`),
-		// Unix timestamps
-		regexp.MustCompile(`^\s*[+-].*\b\d{10,13}\b`),
-		// Common timestamp keywords with dates
-		regexp.MustCompile(`^\s*[+-].*(?:deployed|created|modified|updated|generated):\s*\d{4}-\d{2}-\d{2}`),
-		// ansible_date_time variables
-		regexp.MustCompile(`^\s*[+-].*ansible_date_time\.(iso8601|epoch|date|time)`),
-		// HTML class attributes with timestamps
-		regexp.MustCompile(`^\s*[+-].*class=".*timestamp.*".*\d{4}-\d{2}-\d{2}`),
-		// Generic dynamic IDs or session tokens
-		regexp.MustCompile(`^\s*[+-].*(?:id|token|session|uuid).*[a-f0-9]{8,32}`),
-	}
-
-	lines := strings.Split(output, "\n")
-	hasNonIgnorableChanges := false
-
-	d.logger.Debug().Msg("Starting areChangesIgnorable analysis")
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		lineLower := strings.ToLower(line)
-
-		// Skip headers and metadata
-		if line == "" ||
-			strings.HasPrefix(line, "---") ||
-			strings.HasPrefix(line, "+++") ||
-			strings.HasPrefix(line, "@@") ||
-			strings.HasPrefix(line, "PLAY") ||
-			strings.HasPrefix(line, "TASK") ||
-			strings.HasPrefix(line, "PLAY RECAP") ||
-			strings.HasPrefix(line, "#") {
-			continue
-		}
-
-		// Check for specific task names that are inherently non-idempotent
-		if strings.Contains(lineLower, "task [") {
-			for _, pattern := range ignorablePatterns {
-				if strings.Contains(lineLower, pattern) {
-					// This entire task is ignorable, skip ahead
-					goto nextLine
-				}
-			}
-		}
-
-		// Check if change is ignorable (diff lines)
-		if strings.Contains(line, "-") || strings.Contains(line, "+") {
-			d.logger.Debug().Str("diff_line", line).Msg("Processing diff line")
-			isIgnorable := false
-
-			// First check regex patterns for dynamic content matching (if enabled)
-			if d.server != nil && d.server.Config != nil && d.server.Config.DriftIgnoreDynamicContent {
-				for i, regex := range dynamicContentRegexes {
-					if regex.MatchString(line) {
-						d.logger.Debug().Str("line", line).Int("regex_index", i).Msg("Ignoring dynamic content change")
-						isIgnorable = true
-						break
-					}
-				}
-			}
-
-			// Then check general ignorable patterns
-			if !isIgnorable {
-				for _, pattern := range ignorablePatterns {
-					if strings.Contains(lineLower, pattern) {
-						d.logger.Debug().Str("line", line).Str("pattern", pattern).Msg("Ignoring change due to ignorable pattern")
-						isIgnorable = true
-						break
-					}
-				}
+// parseDriftChanges extracts the tasks that would change each host from
+// check mode output. Diff lines printed by --diff before a host's "changed"
+// line belong to that host.
+func parseDriftChanges(output string) []DriftChange {
+	var changes []DriftChange
+	var task string
+	var diff []string
+
+	for _, line := range strings.Split(output, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "TASK ["), strings.HasPrefix(trimmed, "RUNNING HANDLER ["):
+			task = trimmed[strings.Index(trimmed, "[")+1:]
+			if end := strings.LastIndex(task, "]"); end >= 0 {
+				task = task[:end]
 			}
-
-			if !isIgnorable {
-				d.logger.Debug().Str("non_ignorable_line", line).Msg("Found non-ignorable change")
-				hasNonIgnorableChanges = true
+			diff = nil
+		case strings.HasPrefix(trimmed, "PLAY "):
+			task = ""
+			diff = nil
+		case strings.HasPrefix(trimmed, "changed: ["):
+			host := trimmed[len("changed: ["):]
+			if end := strings.IndexAny(host, "] "); end >= 0 {
+				host = host[:end]
 			}
+			changes = append(changes, DriftChange{Host: host, Task: task, Diff: strings.Join(diff, "\n")})
+			diff = nil
+		case strings.HasPrefix(trimmed, "ok: ["), strings.HasPrefix(trimmed, "skipping: ["), strings.HasPrefix(trimmed, "included: "):
+			diff = nil
+		case task != "" && trimmed != "" && !strings.HasPrefix(trimmed, "fatal: [") && !strings.HasPrefix(trimmed, "["):
+			diff = append(diff, line)
 		}
-
-	nextLine:
 	}
 
-	result := !hasNonIgnorableChanges
-	d.logger.Debug().Bool("all_changes_ignorable", result).Bool("has_non_ignorable", hasNonIgnorableChanges).Msg("areChangesIgnorable result")
-	return result
+	return changes
+}
+
+// truncateDriftOutput keeps the end of long check output, where the recap is
+func truncateDriftOutput(output string) string {
+	if len(output) <= maxDriftOutputBytes {
+		return output
+	}
+	return "... (truncated)\n" + output[len(output)-maxDriftOutputBytes:]
 }
 
-// UpdatePlaybookState updates the state for a specific playbook
-func (d *DriftDetector) UpdatePlaybookState(logicalPath, fullPath, repo, status, targetHosts string) error {
-	d.logger.Info().Str("logicalPath", logicalPath).Str("fullPath", fullPath).Msg("Updating playbook state")
+// UpdatePlaybookState records a finished job as the baseline for drift
+// detection. The commit the job actually ran is stored so later checks only
+// treat the repository as changed when its ref moves.
+func (d *DriftDetector) UpdatePlaybookState(job *Job, fullPath string) error {
+	logicalPath := job.PlaybookPath
+	id := jobDriftID(job)
+	d.logger.Info().Str("drift_id", id).Str("logicalPath", logicalPath).Str("fullPath", fullPath).Msg("Updating playbook state")
 
 	hash, err := d.fileHash(fullPath)
 	if err != nil {
@@ -604,58 +906,130 @@ func (d *DriftDetector) UpdatePlaybookState(logicalPath, fullPath, repo, status,
 		return err
 	}
 
-	commitHash, err := d.getRemoteCommitHash(repo, "main")
-	if err != nil {
-		d.logger.Warn().Err(err).Msg("Failed to get remote commit hash")
-		commitHash = ""
-	}
+	var tracked PlaybookState
+	err = d.store.Update(func(state StateFile) error {
+		// A run without drift settings keeps the ones already tracked
+		schedule := job.DriftSchedule
+		if schedule == nil {
+			schedule = state[id].Schedule
+		}
+		policy := job.RemediationPolicy
+		if policy == "" {
+			policy = state[id].RemediationPolicy
+		}
+		ignoreRules := job.DriftIgnoreRules
+		if ignoreRules == nil {
+			ignoreRules = state[id].IgnoreRules
+		}
+		limits := job.RemediationLimits
+		if limits == nil {
+			limits = state[id].RemediationLimits
+		}
+
+		// Remediation jobs keep the inventory of the entry they remediate
+		inventorySource, inventory := jobInventorySource(job), jobDriftInventory(job)
+		if existing, ok := state[id]; ok && job.DriftID != "" {
+			inventorySource = existing.InventorySource
+			if existing.Inventory != nil {
+				inventory = existing.Inventory
+			}
+		}
 
-	state, err := d.loadState()
+		playbookState := PlaybookState{
+			Playbook:          logicalPath,
+			Repo:              job.RepositoryURL,
+			InventorySource:   inventorySource,
+			Inventory:         inventory,
+			Ref:               job.Ref,
+			LastRun:           time.Now().UTC().Format(time.RFC3339),
+			LastHash:          hash,
+			LastStatus:        job.Status,
+			PlaybookCommit:    job.CommitSHA,
+			TargetHosts:       job.TargetHosts,
+			RunOptions:        job.RunOptions,
+			Environment:       job.Environment,
+			Schedule:          schedule,
+			RemediationPolicy: policy,
+			IgnoreRules:       ignoreRules,
+			RemediationLimits: limits,
+		}
+
+		// New runs keep the safeguard state; only an operator closes the circuit breaker
+		if existing, ok := state[id]; ok {
+			playbookState.RecentRemediations = existing.RecentRemediations
+			playbookState.RemediationFailures = existing.RemediationFailures
+			playbookState.RemediationSuspended = existing.RemediationSuspended
+			playbookState.RemediationSuspendedAt = existing.RemediationSuspendedAt
+		}
+
+		// An approved remediation job is recorded as the playbook's last remediation
+		if job.RemediationOf != "" {
+			playbookState.LastRemediation = job.EndTime.UTC().Format(time.RFC3339)
+			playbookState.LastRemediationStatus = job.Status
+			playbookState.LastRemediationID = job.ID
+		}
+		state[id] = playbookState
+		tracked = playbookState
+		return nil
+	})
 	if err != nil {
+		d.logger.Error().Err(err).Msg("Failed to save drift state")
 		return err
 	}
 
-	state[logicalPath] = PlaybookState{
-		Repo:           repo,
-		LastRun:        time.Now().UTC().Format(time.RFC3339),
-		LastHash:       hash,
-		LastStatus:     status,
-		PlaybookCommit: commitHash,
-		TargetHosts:    targetHosts,
-	}
+	if job.RemediationOf != "" && d.server != nil {
+		d.server.recordDriftRemediation(logicalPath, job.Status)
 
-	if err := d.saveState(state); err != nil {
-		d.logger.Error().Err(err).Msg("Failed to save state file")
-		return err
+		eventType := eventDriftRemediated
+		if job.Status != "completed" {
+			eventType = eventDriftRemediationFailed
+		}
+		d.server.notify(driftNotificationEvent(eventType, id, tracked, DriftCheckResult{
+			Trigger:       "approval",
+			Commit:        job.CommitSHA,
+			Status:        job.Status,
+			RemediationID: job.ID,
+		}))
 	}
 
-	d.logger.Info().Str("stateFile", d.stateFile).Msg("State file updated successfully")
+	d.logger.Info().Str("drift_id", id).Str("playbook", logicalPath).Msg("Drift state updated")
 	return nil
 }
 
-// RemovePlaybookState removes a playbook from the state
-func (d *DriftDetector) RemovePlaybookState(playbookPath string) error {
-	state, err := d.loadState()
+// RemovePlaybookState stops tracking a drift entry
+func (d *DriftDetector) RemovePlaybookState(id string) error {
+	err := d.store.Update(func(state StateFile) error {
+		delete(state, id)
+		return nil
+	})
 	if err != nil {
 		return err
 	}
 
-	delete(state, playbookPath)
-	return d.saveState(state)
+	d.removeHistory(id)
+	d.mu.Lock()
+	delete(d.nextCheck, id)
+	d.mu.Unlock()
+	return nil
 }
 
 // Legacy functions for backward compatibility
-func UpdatePlaybookState(server *Server, logicalPath, fullPath, repo, status, targetHosts string) error {
+func UpdatePlaybookState(server *Server, job *Job, fullPath string) error {
+	if server != nil && server.Drift != nil {
+		return server.Drift.UpdatePlaybookState(job, fullPath)
+	}
 	detector := NewDriftDetector(server)
-	return detector.UpdatePlaybookState(logicalPath, fullPath, repo, status, targetHosts)
+	return detector.UpdatePlaybookState(job, fullPath)
 }
 
-func RemovePlaybookState(playbookPath string) error {
-	detector := NewDriftDetector(nil) // Will use default state file
-	return detector.RemovePlaybookState(playbookPath)
+func RemovePlaybookState(id string) error {
+	detector := NewDriftDetector(nil) // Uses the state store in the default data directory
+	return detector.RemovePlaybookState(id)
 }
 
 func StartDriftDetection(server *Server) {
-	detector := NewDriftDetector(server)
-	detector.Start()
+	if server.Drift == nil {
+		server.Drift = NewDriftDetector(server)
+	}
+	server.Drift.Start()
 }
@@ -0,0 +1,353 @@
+package server
+
+import (
+	"ansible-api/internal/audit"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// driftHistoryLimit is how many check results are kept per playbook
+	driftHistoryLimit = 50
+	// maxDriftOutputBytes bounds the check output stored with the last result
+	maxDriftOutputBytes = 64 * 1024
+
+	driftTriggerSchedule = "schedule"
+	driftTriggerAPI      = "api"
+)
+
+var (
+	errDriftNotTracked   = errors.New("playbook is not tracked for drift")
+	errDriftAmbiguous    = errors.New("playbook is tracked by more than one drift entry; use the entry ID")
+	errDriftCheckRunning = errors.New("a drift check is already running for this playbook")
+)
+
+// Entries returns every tracked playbook with its scheduling status, sorted by
+// playbook and ID
+func (d *DriftDetector) Entries() ([]DriftEntry, error) {
+	state, err := d.store.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]DriftEntry, 0, len(state))
+	for id, playbookState := range state {
+		entries = append(entries, d.entry(id, playbookState))
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Playbook != entries[j].Playbook {
+			return entries[i].Playbook < entries[j].Playbook
+		}
+		return entries[i].ID < entries[j].ID
+	})
+	return entries, nil
+}
+
+// Entry returns a single tracked playbook by drift entry ID, or by playbook
+// path when only one entry tracks that playbook
+func (d *DriftDetector) Entry(target string) (DriftEntry, error) {
+	state, err := d.store.Load()
+	if err != nil {
+		return DriftEntry{}, err
+	}
+
+	if playbookState, ok := state[target]; ok {
+		return d.entry(target, playbookState), nil
+	}
+
+	var matches []string
+	for id, playbookState := range state {
+		if playbookState.Playbook == target {
+			matches = append(matches, id)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return DriftEntry{}, errDriftNotTracked
+	case 1:
+		return d.entry(matches[0], state[matches[0]]), nil
+	}
+	return DriftEntry{}, errDriftAmbiguous
+}
+
+func (d *DriftDetector) entry(id string, playbookState PlaybookState) DriftEntry {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entry := DriftEntry{ID: id, PlaybookState: playbookState, Checking: d.running[id]}
+	if next, ok := d.nextCheck[id]; ok && !next.IsZero() {
+		entry.NextCheck = &next
+	}
+	return entry
+}
+
+// CheckNow starts a check of a drift entry in the background
+func (d *DriftDetector) CheckNow(id string) error {
+	state, err := d.store.Load()
+	if err != nil {
+		return err
+	}
+	playbookState, ok := state[id]
+	if !ok {
+		return errDriftNotTracked
+	}
+
+	d.mu.Lock()
+	if d.running[id] {
+		d.mu.Unlock()
+		return errDriftCheckRunning
+	}
+	d.running[id] = true
+	d.mu.Unlock()
+
+	go d.runScheduledCheck(id, playbookState, driftTriggerAPI, "")
+	return nil
+}
+
+// History returns the recorded check results of a drift entry, newest first
+func (d *DriftDetector) History(id, logicalPath string) (DriftHistory, error) {
+	d.historyMu.Lock()
+	defer d.historyMu.Unlock()
+
+	return d.loadHistory(id, logicalPath)
+}
+
+// appendHistory records a check result, dropping the oldest beyond driftHistoryLimit
+func (d *DriftDetector) appendHistory(id, logicalPath string, result DriftCheckResult) {
+	if d.historyDir == "" {
+		return
+	}
+
+	d.historyMu.Lock()
+	defer d.historyMu.Unlock()
+
+	history, err := d.loadHistory(id, logicalPath)
+	if err != nil {
+		d.logger.Warn().Err(err).Str("drift_id", id).Msg("Failed to read drift history, starting a new one")
+		history = DriftHistory{ID: id, Playbook: logicalPath}
+	}
+
+	// The full output is only kept with the playbook's last check
+	result.Output = ""
+	history.Checks = append([]DriftCheckResult{result}, history.Checks...)
+	if len(history.Checks) > driftHistoryLimit {
+		history.Checks = history.Checks[:driftHistoryLimit]
+	}
+
+	data, err := json.MarshalIndent(history, "", "  ")
+	if err == nil {
+		if err = os.MkdirAll(d.historyDir, 0700); err == nil {
+			err = writeFileAtomic(d.historyPath(id), data, 0600)
+		}
+	}
+	if err != nil {
+		d.logger.Error().Err(err).Str("drift_id", id).Msg("Failed to write drift history")
+	}
+}
+
+func (d *DriftDetector) loadHistory(id, logicalPath string) (DriftHistory, error) {
+	history := DriftHistory{ID: id, Playbook: logicalPath, Checks: []DriftCheckResult{}}
+	if d.historyDir == "" {
+		return history, nil
+	}
+
+	data, err := os.ReadFile(d.historyPath(id))
+	if os.IsNotExist(err) {
+		return history, nil
+	}
+	if err != nil {
+		return history, err
+	}
+	if err := json.Unmarshal(data, &history); err != nil {
+		return history, fmt.Errorf("failed to decode drift history: %w", err)
+	}
+	// Histories moved from playbook-keyed state have no ID yet
+	history.ID = id
+	return history, nil
+}
+
+// removeHistory deletes the check history of an entry that is no longer tracked
+func (d *DriftDetector) removeHistory(id string) {
+	if d.historyDir == "" {
+		return
+	}
+
+	d.historyMu.Lock()
+	defer d.historyMu.Unlock()
+
+	if err := os.Remove(d.historyPath(id)); err != nil && !os.IsNotExist(err) {
+		d.logger.Warn().Err(err).Str("drift_id", id).Msg("Failed to remove drift history")
+	}
+}
+
+func (d *DriftDetector) historyPath(id string) string {
+	return filepath.Join(d.historyDir, filepath.Base(id)+".json")
+}
+
+// splitDriftPath splits a /api/drift/*path parameter into the drift entry
+// (or remediation ID) and a trailing action
+func splitDriftPath(path string) (string, string) {
+	path = strings.Trim(path, "/")
+	i := strings.LastIndex(path, "/")
+	if i < 0 {
+		return path, ""
+	}
+	return path[:i], path[i+1:]
+}
+
+func (s *Server) handleDriftList(c *gin.Context) {
+	entries, err := s.Drift.Entries()
+	if err != nil {
+		s.Logger.Error().Err(err).Msg("Failed to load drift state")
+		c.JSON(500, gin.H{"error": "Failed to load drift state"})
+		return
+	}
+
+	caller := principal(c)
+	visible := make([]DriftEntry, 0, len(entries))
+	for _, entry := range entries {
+		if s.Auth.Allows(caller, entry.Repo, entry.Playbook) {
+			visible = append(visible, entry)
+		}
+	}
+
+	c.JSON(200, gin.H{"playbooks": visible})
+}
+
+// handleDriftGet serves GET /api/drift/<entry> and /api/drift/<entry>/history,
+// where <entry> is a drift entry ID or a playbook tracked by a single entry
+func (s *Server) handleDriftGet(c *gin.Context) {
+	target := strings.Trim(c.Param("path"), "/")
+	entryTarget, action := splitDriftPath(target)
+	history := action == "history"
+	if history {
+		target = entryTarget
+	}
+
+	entry, err := s.Drift.Entry(target)
+	if !s.checkDriftEntry(c, err) || !s.authorizeTarget(c, entry.Repo, entry.Playbook) {
+		return
+	}
+
+	if !history {
+		c.JSON(200, entry)
+		return
+	}
+
+	checks, err := s.Drift.History(entry.ID, entry.Playbook)
+	if err != nil {
+		s.Logger.Error().Err(err).Str("drift_id", entry.ID).Msg("Failed to read drift history")
+		c.JSON(500, gin.H{"error": "Failed to read drift history"})
+		return
+	}
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			c.JSON(400, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		if limit < len(checks.Checks) {
+			checks.Checks = checks.Checks[:limit]
+		}
+	}
+
+	c.JSON(200, checks)
+}
+
+// handleDriftAction serves POST /api/drift/<entry>/check|reset and
+// /api/drift/<remediation_id>/approve|reject
+func (s *Server) handleDriftAction(c *gin.Context) {
+	target, action := splitDriftPath(c.Param("path"))
+
+	switch action {
+	case "check":
+		s.handleDriftCheck(c, target)
+	case "reset":
+		s.handleDriftReset(c, target)
+	case "approve":
+		s.decideRemediation(c, target, true)
+	case "reject":
+		s.decideRemediation(c, target, false)
+	default:
+		c.JSON(404, gin.H{"error": "Unknown drift action"})
+	}
+}
+
+func (s *Server) handleDriftCheck(c *gin.Context, target string) {
+	entry, err := s.Drift.Entry(target)
+	if !s.checkDriftEntry(c, err) || !s.authorizeTarget(c, entry.Repo, entry.Playbook) {
+		return
+	}
+
+	err = s.Drift.CheckNow(entry.ID)
+	if errors.Is(err, errDriftCheckRunning) {
+		c.JSON(409, gin.H{"error": err.Error()})
+		return
+	}
+	if !s.checkDriftEntry(c, err) {
+		return
+	}
+
+	s.recordAudit(c, audit.Event{
+		Action:        "drift.check",
+		RepositoryURL: entry.Repo,
+		PlaybookPath:  entry.Playbook,
+		Ref:           entry.Ref,
+		TargetHosts:   entry.TargetHosts,
+		Status:        "started",
+		Detail:        entry.ID,
+	})
+
+	s.Logger.Info().Str("drift_id", entry.ID).Str("playbook", entry.Playbook).Msg("Drift check requested")
+	c.JSON(202, gin.H{"status": "started", "id": entry.ID, "playbook": entry.Playbook})
+}
+
+// handleDriftRemove serves DELETE /api/drift/<entry>
+func (s *Server) handleDriftRemove(c *gin.Context) {
+	entry, err := s.Drift.Entry(strings.Trim(c.Param("path"), "/"))
+	if !s.checkDriftEntry(c, err) || !s.authorizeTarget(c, entry.Repo, entry.Playbook) {
+		return
+	}
+
+	if err := s.Drift.RemovePlaybookState(entry.ID); err != nil {
+		s.Logger.Error().Err(err).Str("drift_id", entry.ID).Msg("Failed to remove playbook from drift state")
+		c.JSON(500, gin.H{"error": "Failed to remove playbook from drift state"})
+		return
+	}
+
+	s.recordAudit(c, audit.Event{
+		Action:        "drift.remove",
+		RepositoryURL: entry.Repo,
+		PlaybookPath:  entry.Playbook,
+		Ref:           entry.Ref,
+		TargetHosts:   entry.TargetHosts,
+		Detail:        entry.ID,
+	})
+
+	s.Logger.Info().Str("drift_id", entry.ID).Str("playbook", entry.Playbook).Msg("Playbook removed from drift detection")
+	c.JSON(200, gin.H{"status": "removed", "id": entry.ID, "playbook": entry.Playbook})
+}
+
+// checkDriftEntry writes the error response for a failed drift state lookup
+func (s *Server) checkDriftEntry(c *gin.Context, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, errDriftNotTracked):
+		c.JSON(404, gin.H{"error": "Playbook is not tracked for drift"})
+	case errors.Is(err, errDriftAmbiguous):
+		c.JSON(409, gin.H{"error": err.Error()})
+	default:
+		s.Logger.Error().Err(err).Msg("Failed to load drift state")
+		c.JSON(500, gin.H{"error": "Failed to load drift state"})
+	}
+	return false
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// diffContextLines is how many unchanged lines surround each hunk
+	diffContextLines = 3
+	// maxDiffCells bounds the line comparison table; larger changes are shown
+	// as a single replaced block
+	maxDiffCells = 1 << 20
+	// maxDriftDiffBytes bounds the before/after content and rendered diff stored per change
+	maxDriftDiffBytes = 16 * 1024
+)
+
+// diffOp is one line of a diff: ' ' unchanged, '-' removed or '+' added
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// driftChangesFromResult lists, per host and task, everything check mode
+// reports would change, with the before/after content of its diffs
+func driftChangesFromResult(result *PlaybookResult) []DriftChange {
+	var changes []DriftChange
+	for _, play := range result.Plays {
+		for _, task := range play.Tasks {
+			for _, host := range sortedHosts(task.Hosts) {
+				hostResult := task.Hosts[host]
+				if hostResult.Status != "changed" {
+					continue
+				}
+
+				change := DriftChange{
+					Host:   host,
+					Task:   task.Name,
+					Module: task.Action,
+					Path:   hostResult.Path,
+				}
+
+				var rendered []string
+				for _, diff := range hostResult.Diff {
+					if text := renderTaskDiff(diff); text != "" {
+						rendered = append(rendered, text)
+					}
+					change.Files = append(change.Files, truncateTaskDiff(diff))
+				}
+				change.Diff = truncateDiffText(strings.Join(rendered, "\n"))
+				changes = append(changes, change)
+			}
+		}
+	}
+	return changes
+}
+
+// renderTaskDiff renders a module diff as a unified diff, preferring the
+// module's own rendering when it provides one
+func renderTaskDiff(diff TaskDiff) string {
+	if diff.Prepared != "" {
+		return strings.TrimRight(diff.Prepared, "\n")
+	}
+	if diff.Before == diff.After {
+		return ""
+	}
+
+	before, after := diff.BeforeHeader, diff.AfterHeader
+	if before == "" {
+		before = "before"
+	}
+	if after == "" {
+		after = "after"
+	}
+	return unifiedDiff(before, after, diff.Before, diff.After)
+}
+
+// unifiedDiff renders the line differences between two texts with
+// diffContextLines of context and line-numbered hunk headers
+func unifiedDiff(beforeHeader, afterHeader, before, after string) string {
+	ops := diffLines(splitLines(before), splitLines(after))
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", beforeHeader, afterHeader)
+
+	// Index of every changed op, then grow each run of changes by the context
+	var changed []int
+	for i, op := range ops {
+		if op.kind != ' ' {
+			changed = append(changed, i)
+		}
+	}
+
+	for i := 0; i < len(changed); {
+		start := max(changed[i]-diffContextLines, 0)
+		end := changed[i]
+		for i < len(changed) && changed[i] <= end+2*diffContextLines {
+			end = changed[i]
+			i++
+		}
+		end = min(end+diffContextLines, len(ops)-1)
+
+		// Line numbers of the hunk start in each text
+		beforeLine, afterLine := 1, 1
+		for _, op := range ops[:start] {
+			if op.kind != '+' {
+				beforeLine++
+			}
+			if op.kind != '-' {
+				afterLine++
+			}
+		}
+		beforeCount, afterCount := 0, 0
+		for _, op := range ops[start : end+1] {
+			if op.kind != '+' {
+				beforeCount++
+			}
+			if op.kind != '-' {
+				afterCount++
+			}
+		}
+		if beforeCount == 0 {
+			beforeLine--
+		}
+		if afterCount == 0 {
+			afterLine--
+		}
+
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", beforeLine, beforeCount, afterLine, afterCount)
+		for _, op := range ops[start : end+1] {
+			out.WriteByte(op.kind)
+			out.WriteString(op.line)
+			out.WriteByte('\n')
+		}
+	}
+
+	return strings.TrimRight(out.String(), "\n")
+}
+
+// diffLines computes a line diff. Common leading and trailing lines are
+// matched first; the rest is compared with a longest common subsequence.
+func diffLines(a, b []string) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	ops = append(ops, diffMiddle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
+
+func diffMiddle(a, b []string) []diffOp {
+	var ops []diffOp
+	if len(a)*len(b) > maxDiffCells {
+		for _, line := range a {
+			ops = append(ops, diffOp{'-', line})
+		}
+		for _, line := range b {
+			ops = append(ops, diffOp{'+', line})
+		}
+		return ops
+	}
+
+	// lcs[i][j] is the longest common subsequence of a[i:] and b[j:]
+	width := len(b) + 1
+	lcs := make([]int32, (len(a)+1)*width)
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i*width+j] = lcs[(i+1)*width+j+1] + 1
+			} else {
+				lcs[i*width+j] = max(lcs[(i+1)*width+j], lcs[i*width+j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[(i+1)*width+j] >= lcs[i*width+j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+	return ops
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// truncateTaskDiff bounds the before/after content kept with a change
+func truncateTaskDiff(diff TaskDiff) TaskDiff {
+	for _, content := range []*string{&diff.Before, &diff.After, &diff.Prepared} {
+		if len(*content) > maxDriftDiffBytes {
+			*content = (*content)[:maxDriftDiffBytes] + "\n... (truncated)"
+		}
+	}
+	return diff
+}
+
+func truncateDiffText(text string) string {
+	if len(text) <= maxDriftDiffBytes {
+		return text
+	}
+	return text[:maxDriftDiffBytes] + "\n... (truncated)"
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"ansible-api/internal/audit"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// remediationRateWindow is the period max_per_hour counts remediations over
+	remediationRateWindow = time.Hour
+
+	// Drift statuses of automatic remediations held back by a safeguard
+	remediationRateLimited = "rate_limited"
+	remediationSuspended   = "remediation_suspended"
+)
+
+// remediationLimits applies a playbook's remediation limits over the server defaults
+func (d *DriftDetector) remediationLimits(state PlaybookState) RemediationLimits {
+	var limits RemediationLimits
+	if d.server != nil && d.server.Config != nil {
+		config := d.server.Config
+		limits = RemediationLimits{
+			MaxPerHour:       config.DriftRemediationMaxPerHour,
+			MaxHosts:         config.DriftRemediationMaxHosts,
+			FailureThreshold: config.DriftRemediationFailureThreshold,
+		}
+	}
+
+	if override := state.RemediationLimits; override != nil {
+		if override.MaxPerHour != 0 {
+			limits.MaxPerHour = override.MaxPerHour
+		}
+		if override.MaxHosts != 0 {
+			limits.MaxHosts = override.MaxHosts
+		}
+		if override.FailureThreshold != 0 {
+			limits.FailureThreshold = override.FailureThreshold
+		}
+	}
+	return limits
+}
+
+// remediationBlocked returns the status of an automatic remediation the
+// safeguards hold back at now, or "" if it may run
+func remediationBlocked(state PlaybookState, limits RemediationLimits, now time.Time) string {
+	if state.RemediationSuspended {
+		return remediationSuspended
+	}
+	if limits.MaxPerHour > 0 && len(recentRemediations(state.RecentRemediations, now)) >= limits.MaxPerHour {
+		return remediationRateLimited
+	}
+	return ""
+}
+
+// remediationOutstanding reports whether the last check left drift for a
+// later automatic remediation
+func remediationOutstanding(state PlaybookState) bool {
+	return len(state.DeferredHosts) > 0 ||
+		state.LastStatus == remediationRateLimited ||
+		state.LastStatus == remediationSuspended
+}
+
+// recentRemediations drops remediation times that fell out of the rate window
+func recentRemediations(times []string, now time.Time) []string {
+	var recent []string
+	for _, value := range times {
+		t, err := time.Parse(time.RFC3339, value)
+		if err == nil && now.Sub(t) < remediationRateWindow {
+			recent = append(recent, value)
+		}
+	}
+	return recent
+}
+
+// remediationBatch splits the hosts with drift that was not ignored into the
+// first maxHosts, remediated now, and the rest
+func remediationBatch(changes []DriftChange, maxHosts int) ([]string, []string) {
+	seen := make(map[string]bool)
+	var hosts []string
+	for _, change := range changes {
+		if change.IgnoredBy == "" && change.Host != "" && !seen[change.Host] {
+			seen[change.Host] = true
+			hosts = append(hosts, change.Host)
+		}
+	}
+	sort.Strings(hosts)
+
+	if maxHosts <= 0 || len(hosts) <= maxHosts {
+		return hosts, nil
+	}
+	return hosts[:maxHosts], hosts[maxHosts:]
+}
+
+// recordRemediationOutcome updates the rate window and circuit breaker of a
+// playbook after an automatic remediation. It reports whether the breaker tripped.
+func recordRemediationOutcome(state *PlaybookState, limits RemediationLimits, status string, now time.Time) bool {
+	state.RecentRemediations = append(recentRemediations(state.RecentRemediations, now), now.UTC().Format(time.RFC3339))
+
+	switch status {
+	case "ok":
+		state.RemediationFailures = 0
+	case "error":
+		state.RemediationFailures++
+	}
+
+	if state.RemediationSuspended || limits.FailureThreshold <= 0 || state.RemediationFailures < limits.FailureThreshold {
+		return false
+	}
+	state.RemediationSuspended = true
+	state.RemediationSuspendedAt = now.UTC().Format(time.RFC3339)
+	return true
+}
+
+// ResetRemediation closes the circuit breaker of a drift entry so automatic
+// remediation resumes, and returns the failure count it cleared
+func (d *DriftDetector) ResetRemediation(id string) (int, error) {
+	failures := 0
+	err := d.store.Update(func(state StateFile) error {
+		playbookState, ok := state[id]
+		if !ok {
+			return errDriftNotTracked
+		}
+
+		failures = playbookState.RemediationFailures
+		playbookState.RemediationFailures = 0
+		playbookState.RemediationSuspended = false
+		playbookState.RemediationSuspendedAt = ""
+		state[id] = playbookState
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	d.logger.Info().Str("drift_id", id).Int("failures", failures).Msg("Remediation circuit breaker reset")
+	return failures, nil
+}
+
+// handleDriftReset serves POST /api/drift/<entry>/reset
+func (s *Server) handleDriftReset(c *gin.Context, target string) {
+	entry, err := s.Drift.Entry(target)
+	if !s.checkDriftEntry(c, err) || !s.authorizeTarget(c, entry.Repo, entry.Playbook) {
+		return
+	}
+
+	failures, err := s.Drift.ResetRemediation(entry.ID)
+	if !s.checkDriftEntry(c, err) {
+		return
+	}
+
+	s.recordAudit(c, audit.Event{
+		Action:        "drift.remediation.reset",
+		RepositoryURL: entry.Repo,
+		PlaybookPath:  entry.Playbook,
+		Ref:           entry.Ref,
+		TargetHosts:   entry.TargetHosts,
+		Status:        "reset",
+		Detail:        entry.ID,
+	})
+
+	s.Logger.Info().Str("drift_id", entry.ID).Str("playbook", entry.Playbook).Msg("Remediation circuit breaker reset")
+	c.JSON(200, gin.H{
+		"status":               "reset",
+		"id":                   entry.ID,
+		"playbook":             entry.Playbook,
+		"was_suspended":        entry.RemediationSuspended,
+		"remediation_failures": failures,
+	})
+}
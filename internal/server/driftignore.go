@@ -0,0 +1,274 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// diffHunkHeaderRegex matches a unified diff hunk header and its line counts
+var diffHunkHeaderRegex = regexp.MustCompile(`^@@ -\d+(?:,(\d+))? \+\d+(?:,(\d+))? @@`)
+
+// dynamicContentRuleName is the built-in rule enabled by drift_ignore_dynamic_content
+const dynamicContentRuleName = "builtin:dynamic-content"
+
+// dynamicContentRule ignores changes whose removed and added lines are the
+// same once timestamps and generated IDs are masked. Values that need context
+// to be told apart from other numbers only mask their capture group.
+var dynamicContentRule = DriftIgnoreRule{
+	Name: dynamicContentRuleName,
+	DiffMask: strings.Join([]string{
+		// ISO 8601 timestamps
+		`\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:Z|[+-]\d{2}:?\d{2})?`,
+		// Date and time
+		`\d{4}-\d{2}-\d{2}\s+\d{2}:\d{2}:\d{2}`,
+		// Unix timestamps of a time-like key
+		`(?i:time|epoch|stamp|date|_at)\w*["']?\s*[:=]\s*["']?(\d{10}|\d{13})\b`,
+		// Dates of a timestamp keyword
+		`(?:deployed|created|modified|updated|generated):\s*(\d{4}-\d{2}-\d{2})`,
+		// UUIDs
+		`\b(?i:[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})\b`,
+	}, "|"),
+}
+
+// diffMaskPlaceholder replaces text masked by a diff_mask pattern
+const diffMaskPlaceholder = "\x00"
+
+// defaultIgnoreRules builds the global drift ignore rules from the configuration
+func defaultIgnoreRules(config *Config) ([]compiledIgnoreRule, error) {
+	var rules []DriftIgnoreRule
+	if config.DriftIgnoreDynamicContent != nil && *config.DriftIgnoreDynamicContent {
+		rules = append(rules, dynamicContentRule)
+	}
+
+	if config.DriftIgnoreRules != "" {
+		var configured []DriftIgnoreRule
+		if err := json.Unmarshal([]byte(config.DriftIgnoreRules), &configured); err != nil {
+			return nil, fmt.Errorf("invalid drift_ignore_rules: %w", err)
+		}
+		rules = append(rules, configured...)
+	}
+
+	compiled, err := compileIgnoreRules(rules)
+	if err != nil {
+		return nil, fmt.Errorf("invalid drift_ignore_rules: %w", err)
+	}
+	return compiled, nil
+}
+
+// compileIgnoreRules checks and compiles ignore rules
+func compileIgnoreRules(rules []DriftIgnoreRule) ([]compiledIgnoreRule, error) {
+	compiled := make([]compiledIgnoreRule, 0, len(rules))
+	for _, rule := range rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("ignore rule must have a name")
+		}
+		if rule.Task == "" && rule.Module == "" && rule.Host == "" && rule.Path == "" && rule.DiffLine == "" && rule.DiffMask == "" {
+			return nil, fmt.Errorf("ignore rule %q must set at least one of task, module, host, path, diff_line or diff_mask", rule.Name)
+		}
+
+		c := compiledIgnoreRule{DriftIgnoreRule: rule}
+		var err error
+		if rule.Task != "" {
+			if c.task, err = regexp.Compile(rule.Task); err != nil {
+				return nil, fmt.Errorf("ignore rule %q: invalid task pattern: %w", rule.Name, err)
+			}
+		}
+		if rule.DiffLine != "" {
+			if c.diffLine, err = regexp.Compile(rule.DiffLine); err != nil {
+				return nil, fmt.Errorf("ignore rule %q: invalid diff_line pattern: %w", rule.Name, err)
+			}
+		}
+		if rule.DiffMask != "" {
+			if c.diffMask, err = regexp.Compile(rule.DiffMask); err != nil {
+				return nil, fmt.Errorf("ignore rule %q: invalid diff_mask pattern: %w", rule.Name, err)
+			}
+		}
+		for _, pattern := range []string{rule.Host, rule.Path} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("ignore rule %q: invalid pattern %q: %w", rule.Name, pattern, err)
+			}
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+// ignoreRules returns the global rules followed by the playbook's own.
+// Playbook rules are validated when the job is submitted; any that fail to
+// compile later are skipped.
+func (d *DriftDetector) ignoreRules(state PlaybookState) []compiledIgnoreRule {
+	rules := append([]compiledIgnoreRule{}, d.globalIgnoreRules...)
+	for _, rule := range state.IgnoreRules {
+		compiled, err := compileIgnoreRules([]DriftIgnoreRule{rule})
+		if err != nil {
+			d.logger.Warn().Err(err).Str("playbook", state.Playbook).Msg("Skipping invalid drift ignore rule")
+			continue
+		}
+		rules = append(rules, compiled...)
+	}
+	return rules
+}
+
+// applyIgnoreRules marks each change matched by a rule with the rule's name
+// and returns how many changes are left as drift
+func applyIgnoreRules(changes []DriftChange, rules []compiledIgnoreRule) int {
+	remaining := 0
+	for i := range changes {
+		changes[i].IgnoredBy = ""
+		for _, rule := range rules {
+			if rule.matches(changes[i]) {
+				changes[i].IgnoredBy = rule.Name
+				break
+			}
+		}
+		if changes[i].IgnoredBy == "" {
+			remaining++
+		}
+	}
+	return remaining
+}
+
+// matches reports whether the change meets every criterion the rule sets
+func (r compiledIgnoreRule) matches(change DriftChange) bool {
+	if r.task != nil && !r.task.MatchString(change.Task) {
+		return false
+	}
+	if r.Module != "" && change.Module != r.Module && !strings.HasSuffix(change.Module, "."+r.Module) {
+		return false
+	}
+	if r.Host != "" {
+		if ok, _ := path.Match(r.Host, change.Host); !ok {
+			return false
+		}
+	}
+	if r.Path != "" {
+		if ok, _ := path.Match(r.Path, change.Path); !ok {
+			return false
+		}
+	}
+	if r.diffLine != nil {
+		removed, added := changedDiffLines(change.Diff)
+		lines := append(removed, added...)
+		if len(lines) == 0 {
+			return false
+		}
+		for _, line := range lines {
+			if !r.diffLine.MatchString(line) {
+				return false
+			}
+		}
+	}
+	if r.diffMask != nil && !r.masksDiff(change.Diff) {
+		return false
+	}
+	return true
+}
+
+// masksDiff reports whether each removed line of a diff turns into the added
+// line in the same position once the rule's diff_mask is applied to both.
+// Lines that are identical before masking moved rather than changed, so they
+// do not match.
+func (r compiledIgnoreRule) masksDiff(diff string) bool {
+	removed, added := changedDiffLines(diff)
+	if len(removed) == 0 || len(removed) != len(added) {
+		return false
+	}
+	for i := range removed {
+		if removed[i] == added[i] || maskLine(r.diffMask, removed[i]) != maskLine(r.diffMask, added[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// maskLine replaces the text of each match of pattern in line with a
+// placeholder: the capture groups that took part in the match, or the whole
+// match when none did
+func maskLine(pattern *regexp.Regexp, line string) string {
+	var masked strings.Builder
+	last := 0
+	for _, match := range pattern.FindAllStringSubmatchIndex(line, -1) {
+		var spans [][2]int
+		for g := 2; g < len(match); g += 2 {
+			if match[g] >= 0 {
+				spans = append(spans, [2]int{match[g], match[g+1]})
+			}
+		}
+		if len(spans) == 0 {
+			spans = append(spans, [2]int{match[0], match[1]})
+		}
+		for _, span := range spans {
+			if span[0] < last {
+				continue
+			}
+			masked.WriteString(line[last:span[0]])
+			masked.WriteString(diffMaskPlaceholder)
+			last = span[1]
+		}
+	}
+	masked.WriteString(line[last:])
+	return masked.String()
+}
+
+// changedDiffLines returns the removed and added lines of a unified diff,
+// without their +/- marker, each in diff order. Inside a hunk the first
+// character of each line is its marker, so content starting with "---" or
+// "+++" is kept; outside hunks those lines are file headers.
+func changedDiffLines(diff string) ([]string, []string) {
+	var removed, added []string
+	before, after := 0, 0 // lines left in the current hunk
+	for _, line := range strings.Split(diff, "\n") {
+		if before > 0 || after > 0 {
+			switch {
+			case line == "":
+				// Context line of an empty line whose leading space was stripped
+				before--
+				after--
+			case line[0] == '-':
+				before--
+				removed = append(removed, line[1:])
+			case line[0] == '+':
+				after--
+				added = append(added, line[1:])
+			case line[0] == '\\':
+				// "\ No newline at end of file"
+			default:
+				before--
+				after--
+			}
+			continue
+		}
+
+		if m := diffHunkHeaderRegex.FindStringSubmatch(line); m != nil {
+			before, after = hunkLineCount(m[1]), hunkLineCount(m[2])
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "---") || strings.HasPrefix(line, "+++") {
+			continue
+		}
+		// Diffs rendered without hunk headers
+		switch line[0] {
+		case '-':
+			removed = append(removed, line[1:])
+		case '+':
+			added = append(added, line[1:])
+		}
+	}
+	return removed, added
+}
+
+// hunkLineCount parses a hunk header line count, which defaults to 1 when omitted
+func hunkLineCount(value string) int {
+	if value == "" {
+		return 1
+	}
+	count, err := strconv.Atoi(value)
+	if err != nil {
+		return 0
+	}
+	return count
+}
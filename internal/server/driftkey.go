@@ -0,0 +1,94 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"path"
+	"sort"
+	"strings"
+)
+
+// driftInventoryRepository is the inventory source of runs that used the
+// inventory file in the repository
+const driftInventoryRepository = "repository"
+
+// driftEntryID derives the stable ID of a drift entry from what identifies a
+// tracked run: repository, ref, playbook, target set and inventory source.
+// Equivalent spellings of the repository URL and target list share an ID.
+func driftEntryID(repo, ref, playbook, targetHosts, inventorySource string) string {
+	key := strings.Join([]string{
+		normalizeRepoURL(repo),
+		ref,
+		strings.TrimPrefix(path.Clean("/"+playbook), "/"),
+		normalizeTargetHosts(targetHosts),
+		inventorySource,
+	}, "\x00")
+
+	sum := sha256.Sum256([]byte(key))
+	return "drift-" + hex.EncodeToString(sum[:8])
+}
+
+// driftID returns the ID of the entry tracking this state
+func (s PlaybookState) driftID() string {
+	return driftEntryID(s.Repo, s.Ref, s.Playbook, s.TargetHosts, s.InventorySource)
+}
+
+// jobDriftID returns the ID of the drift entry a finished job is tracked under
+func jobDriftID(job *Job) string {
+	if job.DriftID != "" {
+		return job.DriftID
+	}
+	return driftEntryID(job.RepositoryURL, job.Ref, job.PlaybookPath, job.TargetHosts, jobInventorySource(job))
+}
+
+// jobInventorySource identifies the inventory a job ran against: the
+// repository's inventory file, or the inventory supplied with the request
+func jobInventorySource(job *Job) string {
+	if len(job.Inventory) == 0 {
+		return driftInventoryRepository
+	}
+
+	// Map keys are encoded in sorted order, so equal inventories hash equally
+	data, _ := json.Marshal(job.Inventory)
+	sum := sha256.Sum256(data)
+	return "request:" + hex.EncodeToString(sum[:6])
+}
+
+// jobDriftInventory returns the effective inventory of a job for drift tracking
+func jobDriftInventory(job *Job) *DriftInventory {
+	switch {
+	case len(job.Inventory) > 0:
+		return &DriftInventory{Groups: job.Inventory}
+	case job.InventoryFile != "":
+		return &DriftInventory{File: job.InventoryFile}
+	}
+	return nil
+}
+
+// requestInventory returns the request-provided inventory of the tracked run, if any
+func (s PlaybookState) requestInventory() map[string]map[string]string {
+	if s.Inventory == nil {
+		return nil
+	}
+	return s.Inventory.Groups
+}
+
+// normalizeTargetHosts turns an Ansible limit pattern into a sorted,
+// de-duplicated list so the order hosts were given in does not matter
+func normalizeTargetHosts(targetHosts string) string {
+	fields := strings.FieldsFunc(targetHosts, func(r rune) bool {
+		return r == ',' || r == ':' || r == ' '
+	})
+
+	seen := make(map[string]bool, len(fields))
+	hosts := make([]string, 0, len(fields))
+	for _, host := range fields {
+		if !seen[host] {
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts)
+	return strings.Join(hosts, ",")
+}
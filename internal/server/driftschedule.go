@@ -0,0 +1,117 @@
+package server
+
+import (
+	"ansible-api/internal/cron"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	// driftSchedulerTick is how often the detector looks for playbooks that are due
+	driftSchedulerTick = 15 * time.Second
+
+	suppressChecks      = "checks"
+	suppressRemediation = "remediation"
+)
+
+// defaultDriftSchedule builds the server-wide drift schedule from the configuration
+func defaultDriftSchedule(config *Config) (DriftSchedule, error) {
+	schedule := DriftSchedule{
+		IntervalSeconds: config.DriftIntervalSeconds,
+		Cron:            config.DriftCron,
+		JitterSeconds:   config.DriftJitterSeconds,
+	}
+
+	if schedule.Cron != "" {
+		if _, err := cron.Parse(schedule.Cron); err != nil {
+			return schedule, fmt.Errorf("invalid drift_cron: %w", err)
+		}
+	}
+
+	if config.DriftMaintenanceWindows != "" {
+		if err := json.Unmarshal([]byte(config.DriftMaintenanceWindows), &schedule.MaintenanceWindows); err != nil {
+			return schedule, fmt.Errorf("invalid drift_maintenance_windows: %w", err)
+		}
+		for _, window := range schedule.MaintenanceWindows {
+			if _, err := cron.Parse(window.Cron); err != nil {
+				return schedule, fmt.Errorf("invalid drift_maintenance_windows: %w", err)
+			}
+			if window.DurationMinutes < 1 {
+				return schedule, fmt.Errorf("invalid drift_maintenance_windows: duration_minutes must be at least 1")
+			}
+		}
+	}
+
+	return schedule, nil
+}
+
+// effectiveSchedule applies a playbook's schedule over the detector defaults
+func (d *DriftDetector) effectiveSchedule(state PlaybookState) DriftSchedule {
+	schedule := d.defaults
+	schedule.MaintenanceWindows = append([]MaintenanceWindow{}, d.defaults.MaintenanceWindows...)
+
+	if override := state.Schedule; override != nil {
+		if override.Cron != "" || override.IntervalSeconds > 0 {
+			schedule.Cron = override.Cron
+			schedule.IntervalSeconds = override.IntervalSeconds
+		}
+		if override.JitterSeconds > 0 {
+			schedule.JitterSeconds = override.JitterSeconds
+		}
+		schedule.MaintenanceWindows = append(schedule.MaintenanceWindows, override.MaintenanceWindows...)
+	}
+
+	return schedule
+}
+
+// nextDriftCheck returns when a playbook is next due after from, including jitter
+func (d *DriftDetector) nextDriftCheck(schedule DriftSchedule, from time.Time) time.Time {
+	var next time.Time
+	if schedule.Cron != "" {
+		if parsed, err := cron.Parse(schedule.Cron); err == nil {
+			next = parsed.Next(from)
+		} else {
+			d.logger.Warn().Err(err).Str("cron", schedule.Cron).Msg("Invalid drift schedule, using the interval")
+		}
+	}
+	if next.IsZero() {
+		interval := time.Duration(schedule.IntervalSeconds) * time.Second
+		if interval <= 0 {
+			interval = time.Duration(d.defaults.IntervalSeconds) * time.Second
+		}
+		next = from.Add(interval)
+	}
+
+	if schedule.JitterSeconds > 0 {
+		next = next.Add(rand.N(time.Duration(schedule.JitterSeconds) * time.Second))
+	}
+	return next
+}
+
+// activeMaintenance reports what the maintenance windows open at now
+// suppress: "checks", "remediation" or "" when none is open
+func activeMaintenance(windows []MaintenanceWindow, now time.Time) string {
+	suppressed := ""
+	for _, window := range windows {
+		parsed, err := cron.Parse(window.Cron)
+		if err != nil {
+			continue
+		}
+
+		// The window is open if it started within the last DurationMinutes
+		duration := time.Duration(window.DurationMinutes) * time.Minute
+		start := parsed.Next(now.Add(-duration))
+		if start.IsZero() || start.After(now) {
+			continue
+		}
+
+		if window.Suppress == suppressRemediation {
+			suppressed = suppressRemediation
+			continue
+		}
+		return suppressChecks
+	}
+	return suppressed
+}
@@ -0,0 +1,250 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// driftStateSchemaVersion is the current version of the drift state document.
+	// Version 0 is the bare playbook map written before versioning; versions
+	// before 2 key entries by playbook path instead of drift entry ID.
+	driftStateSchemaVersion = 2
+
+	driftStateFileName = "state.json"
+)
+
+// legacyDriftStateFile is where drift state was kept before the state store
+var legacyDriftStateFile = filepath.Join(os.TempDir(), "default_system_state.json")
+
+// NewFileDriftStateStore returns a drift state store in the given directory.
+// The directory is created, and older state migrated, on first use.
+func NewFileDriftStateStore(dir string) *FileDriftStateStore {
+	return &FileDriftStateStore{
+		path:       filepath.Join(dir, driftStateFileName),
+		legacyPath: legacyDriftStateFile,
+		logger:     log.With().Str("component", "drift-store").Logger(),
+	}
+}
+
+// Load returns a snapshot of every tracked playbook
+func (s *FileDriftStateStore) Load() (StateFile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s.read()
+}
+
+// Update applies fn to the current state and atomically writes the result.
+// Updates are serialised, so concurrent read-modify-write cycles never lose
+// each other's changes.
+func (s *FileDriftStateStore) Update(fn func(state StateFile) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.open(); err != nil {
+		return err
+	}
+
+	state, err := s.read()
+	if err != nil {
+		return err
+	}
+	if err := fn(state); err != nil {
+		return err
+	}
+	return s.write(state)
+}
+
+// open creates the store directory and brings the state file up to the
+// current schema, importing the legacy state file if there is no state yet
+func (s *FileDriftStateStore) open() error {
+	if s.opened {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("failed to create drift state directory: %w", err)
+	}
+
+	data, err := os.ReadFile(s.path)
+	switch {
+	case os.IsNotExist(err):
+		if err := s.importLegacy(); err != nil {
+			return err
+		}
+	case err != nil:
+		return fmt.Errorf("failed to read drift state: %w", err)
+	default:
+		doc, err := s.decode(data)
+		if err != nil {
+			return err
+		}
+		if doc.SchemaVersion < driftStateSchemaVersion {
+			if err := s.migrate(data, doc); err != nil {
+				return err
+			}
+		}
+	}
+
+	s.opened = true
+	s.logger.Info().Str("path", s.path).Int("schema_version", driftStateSchemaVersion).Msg("Drift state store opened")
+	return nil
+}
+
+// importLegacy moves the pre-versioning state file into the store. The old
+// file is renamed rather than deleted so it is never imported twice.
+func (s *FileDriftStateStore) importLegacy() error {
+	if s.legacyPath == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.legacyPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read legacy drift state %s: %w", s.legacyPath, err)
+	}
+
+	doc, err := s.decode(data)
+	if err != nil {
+		return fmt.Errorf("failed to import legacy drift state %s: %w", s.legacyPath, err)
+	}
+	if err := s.write(doc.Playbooks); err != nil {
+		return err
+	}
+
+	if err := os.Rename(s.legacyPath, s.legacyPath+".migrated"); err != nil {
+		s.logger.Warn().Err(err).Str("path", s.legacyPath).Msg("Failed to rename legacy drift state file")
+	}
+	s.logger.Info().
+		Str("from", s.legacyPath).
+		Str("to", s.path).
+		Int("playbooks", len(doc.Playbooks)).
+		Msg("Imported legacy drift state")
+	return nil
+}
+
+// migrate rewrites an older state document in the current schema, keeping a
+// copy of the original next to it
+func (s *FileDriftStateStore) migrate(original []byte, doc driftStateDocument) error {
+	backup := fmt.Sprintf("%s.v%d.bak", s.path, doc.SchemaVersion)
+	if err := writeFileAtomic(backup, original, 0600); err != nil {
+		return fmt.Errorf("failed to back up drift state before migration: %w", err)
+	}
+	if err := s.write(doc.Playbooks); err != nil {
+		return err
+	}
+
+	s.logger.Info().
+		Int("from_version", doc.SchemaVersion).
+		Int("to_version", driftStateSchemaVersion).
+		Str("backup", backup).
+		Msg("Migrated drift state")
+	return nil
+}
+
+func (s *FileDriftStateStore) read() (StateFile, error) {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return make(StateFile), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read drift state: %w", err)
+	}
+
+	doc, err := s.decode(data)
+	if err != nil {
+		return nil, err
+	}
+	return doc.Playbooks, nil
+}
+
+func (s *FileDriftStateStore) write(state StateFile) error {
+	doc := driftStateDocument{
+		SchemaVersion: driftStateSchemaVersion,
+		UpdatedAt:     time.Now().UTC(),
+		Playbooks:     state,
+	}
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode drift state: %w", err)
+	}
+	if err := writeFileAtomic(s.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write drift state: %w", err)
+	}
+	return nil
+}
+
+// decode decodes a state document of any known schema version and upgrades
+// its playbooks to the current one. SchemaVersion keeps the version that was read.
+func (s *FileDriftStateStore) decode(data []byte) (driftStateDocument, error) {
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return driftStateDocument{}, fmt.Errorf("failed to decode drift state: %w", err)
+	}
+
+	var doc driftStateDocument
+	if _, versioned := probe["schema_version"]; versioned {
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return doc, fmt.Errorf("failed to decode drift state: %w", err)
+		}
+	} else if err := json.Unmarshal(data, &doc.Playbooks); err != nil {
+		return doc, fmt.Errorf("failed to decode legacy drift state: %w", err)
+	}
+
+	if doc.SchemaVersion > driftStateSchemaVersion {
+		return doc, fmt.Errorf("drift state schema version %d is newer than supported version %d", doc.SchemaVersion, driftStateSchemaVersion)
+	}
+	if doc.Playbooks == nil {
+		doc.Playbooks = make(StateFile)
+	}
+	if doc.SchemaVersion < 2 {
+		doc.Playbooks = s.keyByDriftID(doc.Playbooks)
+	}
+	return doc, nil
+}
+
+// keyByDriftID re-keys entries tracked by playbook path under their drift
+// entry ID, moving their check history along. Entries from before inventory
+// sources were recorded used the repository inventory.
+func (s *FileDriftStateStore) keyByDriftID(legacy StateFile) StateFile {
+	historyDir := filepath.Join(filepath.Dir(s.path), "history")
+
+	state := make(StateFile, len(legacy))
+	for logicalPath, playbookState := range legacy {
+		if playbookState.Playbook == "" {
+			playbookState.Playbook = logicalPath
+		}
+		if playbookState.InventorySource == "" {
+			playbookState.InventorySource = driftInventoryRepository
+		}
+		id := playbookState.driftID()
+		state[id] = playbookState
+
+		oldHistory := legacyDriftHistoryPath(historyDir, logicalPath)
+		if err := os.Rename(oldHistory, filepath.Join(historyDir, id+".json")); err != nil && !os.IsNotExist(err) {
+			s.logger.Warn().Err(err).Str("playbook", logicalPath).Msg("Failed to move drift history")
+		}
+	}
+	return state
+}
+
+// legacyDriftHistoryPath is where the history of a playbook was kept while
+// entries were keyed by playbook path
+func legacyDriftHistoryPath(historyDir, logicalPath string) string {
+	sum := sha256.Sum256([]byte(logicalPath))
+	return filepath.Join(historyDir, hex.EncodeToString(sum[:8])+".json")
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultEmailSubjectTemplate = `[ansible-api] {{.Summary}}`
+
+	defaultEmailBodyTemplate = `{{.Summary}}
+
+{{range facts .}}{{.Name}}: {{.Value}}
+{{end}}{{with .Job}}{{if .Recap}}
+PLAY RECAP
+{{range recap .Recap}}{{.}}
+{{end}}{{end}}{{end}}{{with .Drift}}{{if .Changes}}
+Changes
+{{range changes .Changes}}  {{.}}
+{{end}}{{end}}{{end}}
+Event {{.Type}} ({{.ID}}) at {{.Time.Format "2006-01-02T15:04:05Z07:00"}}
+`
+
+	emailDigestSubjectTemplate = `[ansible-api] Drift digest: {{len .}} finding(s)`
+
+	emailDigestBodyTemplate = `{{len .}} drift finding(s) in this drift cycle.
+{{range .}}
+== {{.Summary}}
+{{range facts .}}{{.Name}}: {{.Value}}
+{{end}}{{with .Drift}}{{range changes .Changes}}  {{.}}
+{{end}}{{end}}{{end}}`
+)
+
+// emailTemplateFuncs are available to email templates
+var emailTemplateFuncs = template.FuncMap{
+	"facts":   eventFacts,
+	"recap":   recapLines,
+	"changes": driftChangeLines,
+}
+
+var (
+	emailDigestSubject = template.Must(template.New("digest-subject").Funcs(emailTemplateFuncs).Parse(emailDigestSubjectTemplate))
+	emailDigestBody    = template.Must(template.New("digest-body").Funcs(emailTemplateFuncs).Parse(emailDigestBodyTemplate))
+)
+
+// parseEmailRecipients reads the notification_email_recipients configuration
+func parseEmailRecipients(value string) ([]EmailRecipient, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	var recipients []EmailRecipient
+	if err := json.Unmarshal([]byte(value), &recipients); err != nil {
+		return nil, fmt.Errorf("invalid notification_email_recipients: %w", err)
+	}
+
+	for i, recipient := range recipients {
+		if _, err := mail.ParseAddress(recipient.Address); err != nil {
+			return nil, fmt.Errorf("invalid notification_email_recipients: recipient %d has an invalid address %q", i, recipient.Address)
+		}
+		for _, event := range recipient.Events {
+			if !slices.Contains(notificationEventTypes, event) {
+				return nil, fmt.Errorf("invalid notification_email_recipients: %s subscribes to unknown event %q", recipient.Address, event)
+			}
+		}
+	}
+	return recipients, nil
+}
+
+// NewEmailNotifier creates an email notifier sending through the configured
+// SMTP server with the configured templates
+func NewEmailNotifier(config *Config, recipients []EmailRecipient) (*EmailNotifier, error) {
+	if config.SMTPHost == "" {
+		return nil, fmt.Errorf("email notifications need smtp_host")
+	}
+	from, err := mail.ParseAddress(config.SMTPFrom)
+	if err != nil {
+		return nil, fmt.Errorf("email notifications need a valid smtp_from address: %w", err)
+	}
+
+	subjectText, bodyText := config.EmailSubjectTemplate, config.EmailBodyTemplate
+	if subjectText == "" {
+		subjectText = defaultEmailSubjectTemplate
+	}
+	if bodyText == "" {
+		bodyText = defaultEmailBodyTemplate
+	}
+	subject, err := template.New("subject").Funcs(emailTemplateFuncs).Parse(subjectText)
+	if err != nil {
+		return nil, fmt.Errorf("invalid email_subject_template: %w", err)
+	}
+	body, err := template.New("body").Funcs(emailTemplateFuncs).Parse(bodyText)
+	if err != nil {
+		return nil, fmt.Errorf("invalid email_body_template: %w", err)
+	}
+
+	// PlainAuth only sends credentials over TLS or to localhost
+	var auth smtp.Auth
+	if config.SMTPUsername != "" {
+		auth = smtp.PlainAuth("", config.SMTPUsername, config.SMTPPassword, config.SMTPHost)
+	}
+
+	n := &EmailNotifier{
+		addr:       net.JoinHostPort(config.SMTPHost, strconv.Itoa(config.SMTPPort)),
+		from:       *from,
+		auth:       auth,
+		recipients: recipients,
+		subject:    subject,
+		body:       body,
+		sendMail:   smtp.SendMail,
+		digests:    make(map[string]map[string][]NotificationEvent),
+		logger:     log.With().Str("component", "email").Logger(),
+	}
+
+	n.logger.Info().Str("smtp", n.addr).Str("from", n.from.Address).Int("recipients", len(recipients)).Msg("Email notifier configured")
+	return n, nil
+}
+
+// Notify emails the event to every subscribed recipient. Drift events of a
+// drift cycle are held for recipients that receive digests.
+func (n *EmailNotifier) Notify(event NotificationEvent) {
+	var to []string
+	for _, recipient := range n.recipients {
+		if len(recipient.Events) > 0 && !slices.Contains(recipient.Events, event.Type) {
+			continue
+		}
+		if recipient.Digest && event.Drift != nil && event.Drift.Cycle != "" {
+			n.mu.Lock()
+			if n.digests[event.Drift.Cycle] == nil {
+				n.digests[event.Drift.Cycle] = make(map[string][]NotificationEvent)
+			}
+			n.digests[event.Drift.Cycle][recipient.Address] = append(n.digests[event.Drift.Cycle][recipient.Address], event)
+			n.mu.Unlock()
+			continue
+		}
+		to = append(to, recipient.Address)
+	}
+	if len(to) == 0 {
+		return
+	}
+
+	subject, body, err := n.render(n.subject, n.body, event)
+	if err != nil {
+		n.logger.Error().Err(err).Str("event", event.Type).Msg("Failed to render email")
+		return
+	}
+	go n.send(to, subject, body, event.Type)
+}
+
+// DriftCycleFinished sends each digest recipient one email with the drift
+// events of the cycle
+func (n *EmailNotifier) DriftCycleFinished(cycle string) {
+	n.mu.Lock()
+	digests := n.digests[cycle]
+	delete(n.digests, cycle)
+	n.mu.Unlock()
+
+	addresses := make([]string, 0, len(digests))
+	for address := range digests {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	for _, address := range addresses {
+		subject, body, err := n.render(emailDigestSubject, emailDigestBody, digests[address])
+		if err != nil {
+			n.logger.Error().Err(err).Str("cycle", cycle).Msg("Failed to render drift digest")
+			continue
+		}
+		go n.send([]string{address}, subject, body, "drift.digest")
+	}
+}
+
+// render executes a subject and body template; the subject is kept to one line
+func (n *EmailNotifier) render(subjectTemplate, bodyTemplate *template.Template, data any) (string, string, error) {
+	var subject, body bytes.Buffer
+	if err := subjectTemplate.Execute(&subject, data); err != nil {
+		return "", "", err
+	}
+	if err := bodyTemplate.Execute(&body, data); err != nil {
+		return "", "", err
+	}
+	return strings.Join(strings.Fields(subject.String()), " "), body.String(), nil
+}
+
+// send delivers one message per recipient, so recipients do not see each other
+func (n *EmailNotifier) send(to []string, subject, body, kind string) {
+	for _, address := range to {
+		msg, err := n.message(address, subject, body)
+		if err == nil {
+			err = n.sendMail(n.addr, n.auth, n.from.Address, []string{address}, msg)
+		}
+		if err != nil {
+			n.logger.Error().Err(err).Str("to", address).Str("event", kind).Msg("Failed to send email notification")
+			continue
+		}
+		n.logger.Info().Str("to", address).Str("event", kind).Str("subject", subject).Msg("Email notification sent")
+	}
+}
+
+// message builds a plain text email with a quoted-printable body
+func (n *EmailNotifier) message(to, subject, body string) ([]byte, error) {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.from.String())
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: quoted-printable\r\n")
+	msg.WriteString("\r\n")
+
+	qp := quotedprintable.NewWriter(&msg)
+	body = strings.ReplaceAll(body, "\r\n", "\n")
+	if _, err := qp.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	return msg.Bytes(), nil
+}
@@ -0,0 +1,263 @@
+package server
+
+import (
+	"net"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// smtpMessage is a message accepted by the SMTP stand-in
+type smtpMessage struct {
+	from string
+	to   []string
+	data string
+}
+
+// smtpStandIn is a minimal SMTP server recording the messages it receives
+type smtpStandIn struct {
+	listener net.Listener
+	mu       sync.Mutex
+	messages []smtpMessage
+	received chan struct{}
+}
+
+func newSMTPStandIn(t *testing.T) *smtpStandIn {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := &smtpStandIn{listener: listener, received: make(chan struct{}, 100)}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpStandIn) serve(conn net.Conn) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+
+	var msg smtpMessage
+	reply := func(format string, args ...any) bool {
+		return text.PrintfLine(format, args...) == nil
+	}
+	if !reply("220 localhost ESMTP stand-in") {
+		return
+	}
+
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			reply("250 localhost")
+		case "MAIL":
+			msg = smtpMessage{from: smtpPath(arg)}
+			reply("250 OK")
+		case "RCPT":
+			msg.to = append(msg.to, smtpPath(arg))
+			reply("250 OK")
+		case "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			msg.data = string(data)
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			s.received <- struct{}{}
+			reply("250 OK")
+		case "RSET", "NOOP":
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+// smtpPath returns the address of a MAIL FROM:<...> or RCPT TO:<...> argument
+func smtpPath(arg string) string {
+	start, end := strings.Index(arg, "<"), strings.LastIndex(arg, ">")
+	if start < 0 || end < start {
+		return arg
+	}
+	return arg[start+1 : end]
+}
+
+// wait returns the messages once n have arrived, failing if more arrive shortly after
+func (s *smtpStandIn) wait(t *testing.T, n int) []smtpMessage {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		select {
+		case <-s.received:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d of %d messages", i, n)
+		}
+	}
+	select {
+	case <-s.received:
+		t.Fatalf("received more than %d messages", n)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	messages := s.messages
+	s.messages = nil
+	return messages
+}
+
+// recipientsOf returns the envelope recipient of each message, sorted
+func recipientsOf(t *testing.T, messages []smtpMessage) []string {
+	t.Helper()
+
+	var recipients []string
+	for _, msg := range messages {
+		if len(msg.to) != 1 {
+			t.Fatalf("message addressed to %v, want a single recipient", msg.to)
+		}
+		recipients = append(recipients, msg.to[0])
+	}
+	sort.Strings(recipients)
+	return recipients
+}
+
+func newTestEmailNotifier(t *testing.T, server *smtpStandIn, recipients []EmailRecipient) *EmailNotifier {
+	t.Helper()
+
+	host, port, err := net.SplitHostPort(server.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("split address: %v", err)
+	}
+	portNumber, _ := strconv.Atoi(port)
+
+	notifier, err := NewEmailNotifier(&Config{SMTPHost: host, SMTPPort: portNumber, SMTPFrom: "ansible-api@example.com"}, recipients)
+	if err != nil {
+		t.Fatalf("NewEmailNotifier: %v", err)
+	}
+	return notifier
+}
+
+func TestEmailNotifierSendsSubscribedEventsPerRecipient(t *testing.T) {
+	server := newSMTPStandIn(t)
+	notifier := newTestEmailNotifier(t, server, []EmailRecipient{
+		{Address: "all@example.com"},
+		{Address: "failures@example.com", Events: []string{eventJobFailed}},
+		{Address: "drift@example.com", Events: []string{eventDriftDetected}},
+	})
+
+	notifier.Notify(NotificationEvent{
+		ID:      "event-1",
+		Type:    eventJobFailed,
+		Time:    time.Now(),
+		Summary: "Job job-1 failed",
+		Job:     &JobNotification{ID: "job-1", Status: "failed"},
+	})
+
+	messages := server.wait(t, 2)
+	got := recipientsOf(t, messages)
+	want := []string{"all@example.com", "failures@example.com"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("recipients = %v, want %v", got, want)
+	}
+	for _, msg := range messages {
+		if msg.from != "ansible-api@example.com" {
+			t.Errorf("sender = %q, want ansible-api@example.com", msg.from)
+		}
+		if !strings.Contains(msg.data, "To: "+msg.to[0]+"\n") {
+			t.Errorf("message to %s does not address only that recipient:\n%s", msg.to[0], msg.data)
+		}
+		if !strings.Contains(msg.data, "Job job-1 failed") {
+			t.Errorf("message to %s is missing the summary:\n%s", msg.to[0], msg.data)
+		}
+	}
+
+	notifier.Notify(NotificationEvent{
+		ID:      "event-2",
+		Type:    eventJobCompleted,
+		Time:    time.Now(),
+		Summary: "Job job-2 completed",
+		Job:     &JobNotification{ID: "job-2", Status: "completed"},
+	})
+
+	got = recipientsOf(t, server.wait(t, 1))
+	if len(got) != 1 || got[0] != "all@example.com" {
+		t.Fatalf("recipients = %v, want [all@example.com]", got)
+	}
+}
+
+func TestEmailNotifierSendsOneDigestPerCycle(t *testing.T) {
+	server := newSMTPStandIn(t)
+	notifier := newTestEmailNotifier(t, server, []EmailRecipient{
+		{Address: "digest@example.com", Digest: true},
+		{Address: "digest-detected@example.com", Events: []string{eventDriftDetected}, Digest: true},
+		{Address: "immediate@example.com", Events: []string{eventDriftDetected}},
+	})
+
+	drift := func(id, eventType string) NotificationEvent {
+		return NotificationEvent{
+			ID:      "event-" + id,
+			Type:    eventType,
+			Time:    time.Now(),
+			Summary: "Drift in " + id,
+			Drift:   &DriftNotification{ID: id, Playbook: id + ".yml", Status: "drift", Cycle: "cycle-1"},
+		}
+	}
+	notifier.Notify(drift("web", eventDriftDetected))
+	notifier.Notify(drift("db", eventDriftDetected))
+	notifier.Notify(drift("cache", eventDriftRemediated))
+
+	// Only the recipient without a digest is mailed during the cycle
+	got := recipientsOf(t, server.wait(t, 2))
+	if strings.Join(got, ",") != "immediate@example.com,immediate@example.com" {
+		t.Fatalf("recipients during the cycle = %v, want immediate@example.com twice", got)
+	}
+
+	notifier.DriftCycleFinished("cycle-1")
+
+	messages := server.wait(t, 2)
+	got = recipientsOf(t, messages)
+	if strings.Join(got, ",") != "digest-detected@example.com,digest@example.com" {
+		t.Fatalf("digest recipients = %v, want one digest each", got)
+	}
+	for _, msg := range messages {
+		switch msg.to[0] {
+		case "digest@example.com":
+			if !strings.Contains(msg.data, "3 finding(s)") {
+				t.Errorf("digest to %s does not hold every event:\n%s", msg.to[0], msg.data)
+			}
+		case "digest-detected@example.com":
+			if !strings.Contains(msg.data, "2 finding(s)") || strings.Contains(msg.data, "Drift in cache") {
+				t.Errorf("digest to %s does not hold only its subscribed events:\n%s", msg.to[0], msg.data)
+			}
+		}
+	}
+
+	// A finished cycle is not sent again
+	notifier.DriftCycleFinished("cycle-1")
+	server.wait(t, 0)
+}
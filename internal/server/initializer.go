@@ -1,14 +1,20 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path"
+	"path/filepath"
 	"regexp"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"ansible-api/internal/ansible"
+	"ansible-api/internal/audit"
+	"ansible-api/internal/cron"
 	"ansible-api/internal/vault"
 
 	"github.com/gin-gonic/gin"
@@ -19,6 +25,17 @@ import (
 )
 
 // Config methods
+func (c *Config) SetBoolValue(key string, value interface{}) {
+	if str, ok := value.(string); ok {
+		if boolVal, err := strconv.ParseBool(str); err == nil {
+			switch key {
+			case "drift_ignore_dynamic_content":
+				c.DriftIgnoreDynamicContent = &boolVal
+			}
+		}
+	}
+}
+
 func (c *Config) SetIntValue(key string, value interface{}) {
 	if str, ok := value.(string); ok {
 		if intVal, err := strconv.Atoi(str); err == nil {
@@ -33,6 +50,24 @@ func (c *Config) SetIntValue(key string, value interface{}) {
 				c.RetentionHours = intVal
 			case "rate_limit":
 				c.RateLimit = intVal
+			case "job_timeout_seconds":
+				c.JobTimeoutSeconds = intVal
+			case "repo_cache_max_mb":
+				c.RepoCacheMaxMB = intVal
+			case "drift_interval_seconds":
+				c.DriftIntervalSeconds = intVal
+			case "drift_jitter_seconds":
+				c.DriftJitterSeconds = intVal
+			case "drift_concurrency":
+				c.DriftConcurrency = intVal
+			case "drift_remediation_max_per_hour":
+				c.DriftRemediationMaxPerHour = intVal
+			case "drift_remediation_max_hosts":
+				c.DriftRemediationMaxHosts = intVal
+			case "drift_remediation_failure_threshold":
+				c.DriftRemediationFailureThreshold = intVal
+			case "smtp_port":
+				c.SMTPPort = intVal
 			}
 		}
 	}
@@ -49,6 +84,32 @@ func (c *Config) SetStringValue(key string, value interface{}) {
 			c.ServerPort = str
 		case "temp_patterns":
 			c.TempPatterns = str
+		case "data_dir":
+			c.DataDir = str
+		case "drift_cron":
+			c.DriftCron = str
+		case "drift_maintenance_windows":
+			c.DriftMaintenanceWindows = str
+		case "drift_remediation_policy":
+			c.DriftRemediationPolicy = str
+		case "drift_ignore_rules":
+			c.DriftIgnoreRules = str
+		case "notification_webhooks":
+			c.NotificationWebhooks = str
+		case "smtp_host":
+			c.SMTPHost = str
+		case "smtp_username":
+			c.SMTPUsername = str
+		case "smtp_password":
+			c.SMTPPassword = str
+		case "smtp_from":
+			c.SMTPFrom = str
+		case "notification_email_recipients":
+			c.NotificationEmailRecipients = str
+		case "email_subject_template":
+			c.EmailSubjectTemplate = str
+		case "email_body_template":
+			c.EmailBodyTemplate = str
 		}
 	}
 }
@@ -105,6 +166,7 @@ func (cm *ConfigManager) loadGitHubConfig(vaultClient *vault.VaultClient, config
 	}
 
 	for key, value := range githubConfig {
+		config.SetBoolValue(key, value)
 		config.SetIntValue(key, value)
 		config.SetStringValue(key, value)
 	}
@@ -120,6 +182,7 @@ func (cm *ConfigManager) loadAPIConfig(vaultClient *vault.VaultClient, config *C
 	}
 
 	for key, value := range apiConfig {
+		config.SetBoolValue(key, value)
 		config.SetIntValue(key, value)
 		config.SetStringValue(key, value)
 	}
@@ -130,15 +193,38 @@ func (cm *ConfigManager) loadAPIConfig(vaultClient *vault.VaultClient, config *C
 // loadFromEnvironment loads configuration from environment variables
 func (cm *ConfigManager) loadFromEnvironment(config *Config) {
 	envVars := map[string]string{
-		"GITHUB_APP_ID":                  "",
-		"GITHUB_INSTALLATION_ID":         "",
-		"GITHUB_PRIVATE_KEY":             "",
-		"GITHUB_API_BASE_URL":            "",
-		"PORT":                           "",
-		"WORKER_COUNT":                   "",
-		"RETENTION_HOURS":                "",
-		"TEMP_PATTERNS":                  "",
-		"RATE_LIMIT_REQUESTS_PER_SECOND": "",
+		"GITHUB_APP_ID":                       "",
+		"GITHUB_INSTALLATION_ID":              "",
+		"GITHUB_PRIVATE_KEY":                  "",
+		"GITHUB_API_BASE_URL":                 "",
+		"PORT":                                "",
+		"WORKER_COUNT":                        "",
+		"RETENTION_HOURS":                     "",
+		"TEMP_PATTERNS":                       "",
+		"RATE_LIMIT_REQUESTS_PER_SECOND":      "",
+		"DATA_DIR":                            "",
+		"JOB_TIMEOUT_SECONDS":                 "",
+		"REPO_CACHE_MAX_MB":                   "",
+		"DRIFT_INTERVAL_SECONDS":              "",
+		"DRIFT_CRON":                          "",
+		"DRIFT_JITTER_SECONDS":                "",
+		"DRIFT_MAINTENANCE_WINDOWS":           "",
+		"DRIFT_CONCURRENCY":                   "",
+		"DRIFT_REMEDIATION_POLICY":            "",
+		"DRIFT_IGNORE_RULES":                  "",
+		"DRIFT_IGNORE_DYNAMIC_CONTENT":        "",
+		"DRIFT_REMEDIATION_MAX_PER_HOUR":      "",
+		"DRIFT_REMEDIATION_MAX_HOSTS":         "",
+		"DRIFT_REMEDIATION_FAILURE_THRESHOLD": "",
+		"NOTIFICATION_WEBHOOKS":               "",
+		"SMTP_HOST":                           "",
+		"SMTP_PORT":                           "",
+		"SMTP_USERNAME":                       "",
+		"SMTP_PASSWORD":                       "",
+		"SMTP_FROM":                           "",
+		"NOTIFICATION_EMAIL_RECIPIENTS":       "",
+		"EMAIL_SUBJECT_TEMPLATE":              "",
+		"EMAIL_BODY_TEMPLATE":                 "",
 	}
 
 	// Load all environment variables
@@ -156,6 +242,29 @@ func (cm *ConfigManager) loadFromEnvironment(config *Config) {
 	cm.setIntFromEnv(config, "RetentionHours", envVars["RETENTION_HOURS"])
 	cm.setStringFromEnv(config, "TempPatterns", envVars["TEMP_PATTERNS"])
 	cm.setIntFromEnv(config, "RateLimit", envVars["RATE_LIMIT_REQUESTS_PER_SECOND"])
+	cm.setStringFromEnv(config, "DataDir", envVars["DATA_DIR"])
+	cm.setIntFromEnv(config, "JobTimeoutSeconds", envVars["JOB_TIMEOUT_SECONDS"])
+	cm.setIntFromEnv(config, "RepoCacheMaxMB", envVars["REPO_CACHE_MAX_MB"])
+	cm.setIntFromEnv(config, "DriftIntervalSeconds", envVars["DRIFT_INTERVAL_SECONDS"])
+	cm.setStringFromEnv(config, "DriftCron", envVars["DRIFT_CRON"])
+	cm.setIntFromEnv(config, "DriftJitterSeconds", envVars["DRIFT_JITTER_SECONDS"])
+	cm.setStringFromEnv(config, "DriftMaintenanceWindows", envVars["DRIFT_MAINTENANCE_WINDOWS"])
+	cm.setIntFromEnv(config, "DriftConcurrency", envVars["DRIFT_CONCURRENCY"])
+	cm.setStringFromEnv(config, "DriftRemediationPolicy", envVars["DRIFT_REMEDIATION_POLICY"])
+	cm.setStringFromEnv(config, "DriftIgnoreRules", envVars["DRIFT_IGNORE_RULES"])
+	cm.setBoolFromEnv(config, "DriftIgnoreDynamicContent", envVars["DRIFT_IGNORE_DYNAMIC_CONTENT"])
+	cm.setIntFromEnv(config, "DriftRemediationMaxPerHour", envVars["DRIFT_REMEDIATION_MAX_PER_HOUR"])
+	cm.setIntFromEnv(config, "DriftRemediationMaxHosts", envVars["DRIFT_REMEDIATION_MAX_HOSTS"])
+	cm.setIntFromEnv(config, "DriftRemediationFailureThreshold", envVars["DRIFT_REMEDIATION_FAILURE_THRESHOLD"])
+	cm.setStringFromEnv(config, "NotificationWebhooks", envVars["NOTIFICATION_WEBHOOKS"])
+	cm.setStringFromEnv(config, "SMTPHost", envVars["SMTP_HOST"])
+	cm.setIntFromEnv(config, "SMTPPort", envVars["SMTP_PORT"])
+	cm.setStringFromEnv(config, "SMTPUsername", envVars["SMTP_USERNAME"])
+	cm.setStringFromEnv(config, "SMTPPassword", envVars["SMTP_PASSWORD"])
+	cm.setStringFromEnv(config, "SMTPFrom", envVars["SMTP_FROM"])
+	cm.setStringFromEnv(config, "NotificationEmailRecipients", envVars["NOTIFICATION_EMAIL_RECIPIENTS"])
+	cm.setStringFromEnv(config, "EmailSubjectTemplate", envVars["EMAIL_SUBJECT_TEMPLATE"])
+	cm.setStringFromEnv(config, "EmailBodyTemplate", envVars["EMAIL_BODY_TEMPLATE"])
 }
 
 // setIntFromEnv sets an integer field from environment variable if not already set
@@ -195,6 +304,75 @@ func (cm *ConfigManager) setIntFromEnv(config *Config, field, value string) {
 				config.RateLimit = intVal
 			}
 		}
+	case "JobTimeoutSeconds":
+		if config.JobTimeoutSeconds == 0 {
+			if intVal, err := strconv.Atoi(value); err == nil {
+				config.JobTimeoutSeconds = intVal
+			}
+		}
+	case "RepoCacheMaxMB":
+		if config.RepoCacheMaxMB == 0 {
+			if intVal, err := strconv.Atoi(value); err == nil {
+				config.RepoCacheMaxMB = intVal
+			}
+		}
+	case "DriftIntervalSeconds":
+		if config.DriftIntervalSeconds == 0 {
+			if intVal, err := strconv.Atoi(value); err == nil {
+				config.DriftIntervalSeconds = intVal
+			}
+		}
+	case "DriftJitterSeconds":
+		if config.DriftJitterSeconds == 0 {
+			if intVal, err := strconv.Atoi(value); err == nil {
+				config.DriftJitterSeconds = intVal
+			}
+		}
+	case "DriftConcurrency":
+		if config.DriftConcurrency == 0 {
+			if intVal, err := strconv.Atoi(value); err == nil {
+				config.DriftConcurrency = intVal
+			}
+		}
+	case "DriftRemediationMaxPerHour":
+		if config.DriftRemediationMaxPerHour == 0 {
+			if intVal, err := strconv.Atoi(value); err == nil {
+				config.DriftRemediationMaxPerHour = intVal
+			}
+		}
+	case "DriftRemediationMaxHosts":
+		if config.DriftRemediationMaxHosts == 0 {
+			if intVal, err := strconv.Atoi(value); err == nil {
+				config.DriftRemediationMaxHosts = intVal
+			}
+		}
+	case "DriftRemediationFailureThreshold":
+		if config.DriftRemediationFailureThreshold == 0 {
+			if intVal, err := strconv.Atoi(value); err == nil {
+				config.DriftRemediationFailureThreshold = intVal
+			}
+		}
+	case "SMTPPort":
+		if config.SMTPPort == 0 {
+			if intVal, err := strconv.Atoi(value); err == nil {
+				config.SMTPPort = intVal
+			}
+		}
+	}
+}
+
+// setBoolFromEnv sets a boolean field from environment variable if not already set
+func (cm *ConfigManager) setBoolFromEnv(config *Config, field, value string) {
+	boolVal, err := strconv.ParseBool(value)
+	if err != nil {
+		return
+	}
+
+	switch field {
+	case "DriftIgnoreDynamicContent":
+		if config.DriftIgnoreDynamicContent == nil {
+			config.DriftIgnoreDynamicContent = &boolVal
+		}
 	}
 }
 
@@ -221,18 +399,78 @@ func (cm *ConfigManager) setStringFromEnv(config *Config, field, value string) {
 		if config.TempPatterns == "" {
 			config.TempPatterns = value
 		}
+	case "DataDir":
+		if config.DataDir == "" {
+			config.DataDir = value
+		}
+	case "DriftCron":
+		if config.DriftCron == "" {
+			config.DriftCron = value
+		}
+	case "DriftMaintenanceWindows":
+		if config.DriftMaintenanceWindows == "" {
+			config.DriftMaintenanceWindows = value
+		}
+	case "DriftRemediationPolicy":
+		if config.DriftRemediationPolicy == "" {
+			config.DriftRemediationPolicy = value
+		}
+	case "DriftIgnoreRules":
+		if config.DriftIgnoreRules == "" {
+			config.DriftIgnoreRules = value
+		}
+	case "NotificationWebhooks":
+		if config.NotificationWebhooks == "" {
+			config.NotificationWebhooks = value
+		}
+	case "SMTPHost":
+		if config.SMTPHost == "" {
+			config.SMTPHost = value
+		}
+	case "SMTPUsername":
+		if config.SMTPUsername == "" {
+			config.SMTPUsername = value
+		}
+	case "SMTPPassword":
+		if config.SMTPPassword == "" {
+			config.SMTPPassword = value
+		}
+	case "SMTPFrom":
+		if config.SMTPFrom == "" {
+			config.SMTPFrom = value
+		}
+	case "NotificationEmailRecipients":
+		if config.NotificationEmailRecipients == "" {
+			config.NotificationEmailRecipients = value
+		}
+	case "EmailSubjectTemplate":
+		if config.EmailSubjectTemplate == "" {
+			config.EmailSubjectTemplate = value
+		}
+	case "EmailBodyTemplate":
+		if config.EmailBodyTemplate == "" {
+			config.EmailBodyTemplate = value
+		}
 	}
 }
 
 // setDefaults sets default values for configuration fields
 func (cm *ConfigManager) setDefaults(config *Config) {
 	defaults := map[string]interface{}{
-		"port":            "8080",
-		"worker_count":    4,
-		"retention_hours": 24,
-		"rate_limit":      10,
-		"temp_patterns":   "*_site.yml,*_hosts",
-		"api_base_url":    "https://api.github.com",
+		"port":                                "8080",
+		"worker_count":                        4,
+		"retention_hours":                     24,
+		"rate_limit":                          10,
+		"job_timeout_seconds":                 3600,
+		"repo_cache_max_mb":                   2048,
+		"drift_interval_seconds":              180,
+		"drift_concurrency":                   4,
+		"temp_patterns":                       "*_site.yml,*_hosts",
+		"api_base_url":                        "https://api.github.com",
+		"data_dir":                            defaultDataDir(),
+		"drift_remediation_policy":            remediationAuto,
+		"drift_remediation_failure_threshold": 3,
+		"smtp_port":                           25,
 	}
 
 	for key, value := range defaults {
@@ -246,7 +484,10 @@ func (cm *ConfigManager) setDefaults(config *Config) {
 
 	// Drift detection defaults - enable optimizations by default
 	config.DriftCheckOnlyOnRepoChange = true // Performance optimization
-	config.DriftIgnoreDynamicContent = true  // Reduce false positives
+	if config.DriftIgnoreDynamicContent == nil {
+		ignoreDynamicContent := true // Reduce false positives
+		config.DriftIgnoreDynamicContent = &ignoreDynamicContent
+	}
 }
 
 // setIntDefault sets an integer default value if not already set
@@ -264,6 +505,30 @@ func (cm *ConfigManager) setIntDefault(config *Config, key string, value int) {
 		if config.RateLimit == 0 {
 			config.RateLimit = value
 		}
+	case "job_timeout_seconds":
+		if config.JobTimeoutSeconds == 0 {
+			config.JobTimeoutSeconds = value
+		}
+	case "repo_cache_max_mb":
+		if config.RepoCacheMaxMB == 0 {
+			config.RepoCacheMaxMB = value
+		}
+	case "drift_interval_seconds":
+		if config.DriftIntervalSeconds == 0 {
+			config.DriftIntervalSeconds = value
+		}
+	case "drift_concurrency":
+		if config.DriftConcurrency == 0 {
+			config.DriftConcurrency = value
+		}
+	case "drift_remediation_failure_threshold":
+		if config.DriftRemediationFailureThreshold == 0 {
+			config.DriftRemediationFailureThreshold = value
+		}
+	case "smtp_port":
+		if config.SMTPPort == 0 {
+			config.SMTPPort = value
+		}
 	}
 }
 
@@ -282,7 +547,24 @@ func (cm *ConfigManager) setStringDefault(config *Config, key string, value stri
 		if config.APIBaseURL == "" {
 			config.APIBaseURL = value
 		}
+	case "data_dir":
+		if config.DataDir == "" {
+			config.DataDir = value
+		}
+	case "drift_remediation_policy":
+		if config.DriftRemediationPolicy == "" {
+			config.DriftRemediationPolicy = value
+		}
+	}
+}
+
+// defaultDataDir returns the directory used for persistent service data
+func defaultDataDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = "."
 	}
+	return filepath.Join(home, ".ansible-api")
 }
 
 // NewServerBuilder creates a new server builder
@@ -367,11 +649,65 @@ func (sb *ServerBuilder) buildServer(config *Config, vaultClient *vault.VaultCli
 		}
 	}
 
+	// Open the persistent job store
+	jobStore, err := NewFileJobStore(filepath.Join(config.DataDir, "jobs"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open job store: %w", err)
+	}
+
+	// Open the repository mirror cache
+	repoCache, err := NewRepoCache(filepath.Join(config.DataDir, "repos"), int64(config.RepoCacheMaxMB)*1024*1024)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository cache: %w", err)
+	}
+
+	// Open the drift state store, migrating older state on first use
+	driftState := NewFileDriftStateStore(filepath.Join(config.DataDir, "drift"))
+	if _, err := driftState.Load(); err != nil {
+		return nil, fmt.Errorf("failed to open drift state: %w", err)
+	}
+
+	// Open the audit log
+	auditLog, err := audit.Open(filepath.Join(config.DataDir, "audit"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	// Open the webhook notifier, resuming deliveries interrupted by a restart
+	webhookTargets, err := parseWebhookTargets(config.NotificationWebhooks)
+	if err != nil {
+		return nil, err
+	}
+	webhooks, err := NewWebhookNotifier(filepath.Join(config.DataDir, "notifications", "webhooks"), webhookTargets)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open webhook notifier: %w", err)
+	}
+	notifiers := []Notifier{webhooks}
+
+	// Email notifications are sent once an SMTP server and recipients are configured
+	emailRecipients, err := parseEmailRecipients(config.NotificationEmailRecipients)
+	if err != nil {
+		return nil, err
+	}
+	if config.SMTPHost != "" && len(emailRecipients) > 0 {
+		email, err := NewEmailNotifier(config, emailRecipients)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, email)
+	}
+
+	// Load API authentication
+	auth, err := NewAuthenticator(vaultClient)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure authentication: %w", err)
+	}
+
 	// Create server instance
 	server := &Server{
 		Router:               router,
 		Logger:               log.With().Str("component", "server").Logger(),
-		Jobs:                 make(map[string]*Job),
+		JobStore:             jobStore,
 		JobQueue:             make(chan *Job, 100),
 		JobMutex:             sync.RWMutex{},
 		RateLimiter:          rate.NewLimiter(rate.Every(time.Second), config.RateLimit),
@@ -381,15 +717,33 @@ func (sb *ServerBuilder) buildServer(config *Config, vaultClient *vault.VaultCli
 		GithubAPIBaseURL:     config.APIBaseURL,
 		VaultClient:          vaultClient,
 		AnsibleClient:        ansibleClient,
+		RepoCache:            repoCache,
+		Auth:                 auth,
+		Audit:                auditLog,
+		DriftState:           driftState,
+		Notifications:        NewNotifications(notifiers...),
+		Webhooks:             webhooks,
 		Config:               config,
 	}
 
 	// Initialize components
+	server.Metrics = NewServerMetrics(server)
+	if vaultClient != nil {
+		vaultClient.SetFailureHook(func(path string) {
+			server.Metrics.VaultFailures.Inc(path)
+		})
+	}
 	server.JobProcessor = NewJobProcessor(server)
+	server.Drift = NewDriftDetector(server)
+	server.JobJanitor = NewJobJanitor(server)
+	server.Tasks = NewTaskRegistry()
+	server.Streams = NewStreamRegistry()
 	server.registerRoutes()
 
 	// Start background processes
-	go server.JobProcessor.ProcessJobs()
+	server.JobProcessor.Start(config.WorkerCount)
+	server.recoverJobs()
+	server.JobJanitor.Start()
 
 	return server, nil
 }
@@ -402,6 +756,20 @@ func (sb *ServerBuilder) initializeRouter() *gin.Engine {
 	return router
 }
 
+// validPlaybookPath reports whether a playbook path is relative and has no ".."
+// segment, so it cannot leave the checkout or escape a role's allow-list
+func validPlaybookPath(playbookPath string) bool {
+	if playbookPath == "" || path.IsAbs(playbookPath) {
+		return false
+	}
+	for _, segment := range strings.Split(playbookPath, "/") {
+		if segment == ".." {
+			return false
+		}
+	}
+	return true
+}
+
 // NewRequestValidator creates a new request validator
 func NewRequestValidator() *RequestValidator {
 	v := validator.New()
@@ -413,6 +781,52 @@ func NewRequestValidator() *RequestValidator {
 		return httpsGitRegex.MatchString(gitURL)
 	})
 
+	// gitref matches branch, tag and commit names without option-like or traversal forms
+	gitRefRegex := regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._/\-]*$`)
+	v.RegisterValidation("gitref", func(fl validator.FieldLevel) bool {
+		ref := fl.Field().String()
+		return gitRefRegex.MatchString(ref) && !strings.Contains(ref, "..")
+	})
+
+	// playbookpath matches playbook paths that stay inside the repository checkout
+	v.RegisterValidation("playbookpath", func(fl validator.FieldLevel) bool {
+		return validPlaybookPath(fl.Field().String())
+	})
+
+	// cron matches five-field cron expressions used by drift schedules
+	v.RegisterValidation("cron", func(fl validator.FieldLevel) bool {
+		_, err := cron.Parse(fl.Field().String())
+		return err == nil
+	})
+
+	// regexp and glob match patterns used by drift ignore rules
+	v.RegisterValidation("regexp", func(fl validator.FieldLevel) bool {
+		_, err := regexp.Compile(fl.Field().String())
+		return err == nil
+	})
+	v.RegisterValidation("glob", func(fl validator.FieldLevel) bool {
+		_, err := path.Match(fl.Field().String(), "")
+		return err == nil
+	})
+
+	// identifier matches environment variable and Ansible variable names
+	identifierRegex := regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	v.RegisterValidation("identifier", func(fl validator.FieldLevel) bool {
+		return identifierRegex.MatchString(fl.Field().String())
+	})
+
+	// envname rejects environment variables that control the server's processes
+	// rather than the playbook: search paths, loaders and Ansible settings
+	v.RegisterValidation("envname", func(fl validator.FieldLevel) bool {
+		return !reservedEnvironmentName(fl.Field().String())
+	})
+
+	// ansibletag matches a single tag name; tags are joined with commas on the command line
+	ansibleTagRegex := regexp.MustCompile(`^[A-Za-z0-9_.\-]+$`)
+	v.RegisterValidation("ansibletag", func(fl validator.FieldLevel) bool {
+		return ansibleTagRegex.MatchString(fl.Field().String())
+	})
+
 	return &RequestValidator{
 		validator: v,
 	}
@@ -420,6 +834,25 @@ func NewRequestValidator() *RequestValidator {
 
 // ValidatePlaybookRequest validates a playbook execution request
 func (rv *RequestValidator) ValidatePlaybookRequest(req *PlaybookRequest) error {
+	if err := rv.validator.Struct(req); err != nil {
+		return err
+	}
+	_, err := compileIgnoreRules(req.DriftIgnoreRules)
+	return err
+}
+
+// ValidateWorkerPoolRequest validates a worker pool resize request
+func (rv *RequestValidator) ValidateWorkerPoolRequest(req *WorkerPoolRequest) error {
+	return rv.validator.Struct(req)
+}
+
+// ValidateJobRetryRequest validates a job retry request
+func (rv *RequestValidator) ValidateJobRetryRequest(req *JobRetryRequest) error {
+	return rv.validator.Struct(req)
+}
+
+// ValidateRemediationDecisionRequest validates a remediation approve or reject request
+func (rv *RequestValidator) ValidateRemediationDecisionRequest(req *RemediationDecisionRequest) error {
 	return rv.validator.Struct(req)
 }
 
@@ -438,12 +871,31 @@ func (s *Server) registerRoutes() {
 	r.Use(gin.Logger())
 	r.Use(gin.Recovery())
 
+	viewer := s.requireRole(RoleViewer)
+	operator := s.requireRole(RoleOperator)
+	admin := s.requireRole(RoleAdmin)
+
 	r.GET("/api/health", s.handleHealth)
-	r.POST("/api/playbook/run", s.handlePlaybookRun)
-	r.POST("/api/execute", s.handlePlaybookRun) // Backward compatibility alias
-	r.GET("/api/jobs", s.handleJobs)
-	r.GET("/api/jobs/:job_id", s.handleJobStatus)
-	r.POST("/api/jobs/:job_id/retry", s.handleJobRetry)
+	r.POST("/api/playbook/run", operator, s.handlePlaybookRun)
+	r.POST("/api/execute", operator, s.handlePlaybookRun) // Backward compatibility alias
+	r.GET("/api/jobs", viewer, s.handleJobs)
+	r.GET("/api/jobs/:job_id", viewer, s.handleJobStatus)
+	r.POST("/api/jobs/:job_id/retry", operator, s.handleJobRetry)
+	r.POST("/api/jobs/:job_id/cancel", operator, s.handleJobCancel)
+	r.GET("/api/jobs/:job_id/stream", viewer, s.handleJobStream)
+	r.GET("/api/workers", viewer, s.handleWorkers)
+	r.PUT("/api/workers", admin, s.handleWorkersResize)
+	r.POST("/api/admin/jobs/cleanup", admin, s.handleJobCleanup)
+	r.GET("/api/repos/cache", admin, s.handleRepoCache)
+	r.GET("/api/audit", admin, s.handleAudit)
+	r.GET("/api/drift", viewer, s.handleDriftList)
+	r.GET("/api/drift/*path", viewer, s.handleDriftGet)
+	r.POST("/api/drift/*path", operator, s.handleDriftAction)
+	r.DELETE("/api/drift/*path", operator, s.handleDriftRemove)
+	r.GET("/api/notifications/deliveries", viewer, s.handleWebhookDeliveries)
+	r.GET("/api/notifications/deliveries/:delivery_id", viewer, s.handleWebhookDelivery)
+	r.POST("/api/notifications/deliveries/:delivery_id/redeliver", operator, s.handleWebhookRedeliver)
+	r.GET("/metrics", viewer, gin.WrapH(s.Metrics.Registry))
 }
 
 // requestLogger middleware logs all HTTP requests with structured data
@@ -525,6 +977,8 @@ func (s *Server) handlePlaybookRun(c *gin.Context) {
 		Str("playbook_path", req.PlaybookPath).
 		Str("target_hosts", req.TargetHosts).
 		Int("inventory_groups", len(req.Inventory)).
+		Int("environment_vars", len(req.Environment)).
+		Int("secrets", len(req.Secrets)).
 		Msg("Request validation passed")
 
 	// Validate request
@@ -539,10 +993,20 @@ func (s *Server) handlePlaybookRun(c *gin.Context) {
 		return
 	}
 
+	if !s.authorizeTarget(c, req.RepositoryURL, req.PlaybookPath) {
+		return
+	}
+
 	// Create and queue job
 	job := s.createJob(&req)
+	job.RequestedBy = principal(c).Name
+	s.JobProcessor.SetSecrets(job.ID, req.Secrets)
 	s.queueJob(job)
 
+	event := jobAuditEvent("job.run", job)
+	event.Request = auditRequestPayload(&req)
+	s.recordAudit(c, event)
+
 	reqLogger.Info().
 		Str("job_id", job.ID).
 		Str("repository_url", req.RepositoryURL).
@@ -561,31 +1025,86 @@ func (s *Server) createJob(req *PlaybookRequest) *Job {
 		Msg("Creating new job")
 
 	return &Job{
-		ID:            jobID,
-		Status:        "queued",
-		StartTime:     time.Now(),
-		RepositoryURL: req.RepositoryURL,
-		PlaybookPath:  req.PlaybookPath,
-		TargetHosts:   req.TargetHosts,
-		Inventory:     req.Inventory,
+		ID:                jobID,
+		Status:            "queued",
+		StartTime:         time.Now(),
+		RepositoryURL:     req.RepositoryURL,
+		PlaybookPath:      req.PlaybookPath,
+		Ref:               req.Ref,
+		TargetHosts:       req.TargetHosts,
+		Inventory:         req.Inventory,
+		TimeoutSeconds:    req.TimeoutSeconds,
+		Environment:       req.Environment,
+		HasSecrets:        len(req.Secrets) > 0,
+		DriftSchedule:     req.DriftSchedule,
+		RemediationPolicy: req.RemediationPolicy,
+		RemediationLimits: req.RemediationLimits,
+		DriftIgnoreRules:  req.DriftIgnoreRules,
+		RunOptions:        req.RunOptions,
 	}
 }
 
 func (s *Server) queueJob(job *Job) {
-	s.JobMutex.Lock()
-	s.Jobs[job.ID] = job
-	jobCount := len(s.Jobs)
-	s.JobMutex.Unlock()
+	s.saveJob(job)
+	s.Streams.Open(job.ID)
 
-	s.JobQueue <- job
+	s.enqueue(job)
 
 	s.Logger.Debug().
 		Str("job_id", job.ID).
-		Int("total_jobs", jobCount).
 		Int("queue_size", len(s.JobQueue)).
 		Msg("Job added to queue")
 }
 
+// enqueue sends a job to the worker queue
+func (s *Server) enqueue(job *Job) {
+	s.QueueMutex.Lock()
+	defer s.QueueMutex.Unlock()
+
+	s.JobQueue <- job
+}
+
+// removeQueuedJob takes a job out of the worker queue and reports whether it
+// was still queued. Senders are held off while the queue is drained and
+// refilled, so the remaining jobs keep their order.
+func (s *Server) removeQueuedJob(jobID string) bool {
+	s.QueueMutex.Lock()
+	defer s.QueueMutex.Unlock()
+
+	var queued []*Job
+drain:
+	for {
+		select {
+		case job := <-s.JobQueue:
+			queued = append(queued, job)
+		default:
+			break drain
+		}
+	}
+
+	removed := false
+	for _, job := range queued {
+		if job.ID == jobID {
+			removed = true
+			continue
+		}
+		s.JobQueue <- job
+	}
+	return removed
+}
+
+// saveJob persists the current state of a job. Callers may still be mutating
+// the job from a worker, so the snapshot is taken under JobMutex.
+func (s *Server) saveJob(job *Job) {
+	s.JobMutex.RLock()
+	snapshot := *job
+	s.JobMutex.RUnlock()
+
+	if err := s.JobStore.Save(&snapshot); err != nil {
+		s.Logger.Error().Err(err).Str("job_id", job.ID).Msg("Failed to persist job")
+	}
+}
+
 func (s *Server) handleJobs(c *gin.Context) {
 	startTime := time.Now()
 	defer func() {
@@ -598,13 +1117,16 @@ func (s *Server) handleJobs(c *gin.Context) {
 			Msg("Jobs list request completed")
 	}()
 
-	s.JobMutex.RLock()
-	jobs := s.Jobs
-	jobCount := len(jobs)
-	s.JobMutex.RUnlock()
+	caller := principal(c)
+	jobs := make(map[string]*Job)
+	for _, job := range s.JobStore.List() {
+		if s.Auth.Allows(caller, job.RepositoryURL, job.PlaybookPath) {
+			jobs[job.ID] = job
+		}
+	}
 
 	s.Logger.Debug().
-		Int("job_count", jobCount).
+		Int("job_count", len(jobs)).
 		Msg("Retrieved jobs list")
 
 	c.JSON(200, jobs)
@@ -628,9 +1150,7 @@ func (s *Server) handleJobStatus(c *gin.Context) {
 
 	reqLogger.Debug().Msg("Job status request received")
 
-	s.JobMutex.RLock()
-	job, exists := s.Jobs[jobID]
-	s.JobMutex.RUnlock()
+	job, exists := s.JobStore.Get(jobID)
 
 	if !exists {
 		reqLogger.Warn().Msg("Job not found")
@@ -638,6 +1158,10 @@ func (s *Server) handleJobStatus(c *gin.Context) {
 		return
 	}
 
+	if !s.authorizeTarget(c, job.RepositoryURL, job.PlaybookPath) {
+		return
+	}
+
 	reqLogger.Debug().
 		Str("job_status", job.Status).
 		Time("start_time", job.StartTime).
@@ -664,9 +1188,7 @@ func (s *Server) handleJobRetry(c *gin.Context) {
 
 	reqLogger.Info().Msg("Job retry request received")
 
-	s.JobMutex.RLock()
-	origJob, exists := s.Jobs[jobID]
-	s.JobMutex.RUnlock()
+	origJob, exists := s.JobStore.Get(jobID)
 
 	if !exists {
 		reqLogger.Warn().Msg("Original job not found for retry")
@@ -674,23 +1196,122 @@ func (s *Server) handleJobRetry(c *gin.Context) {
 		return
 	}
 
+	if !s.authorizeTarget(c, origJob.RepositoryURL, origJob.PlaybookPath) {
+		return
+	}
+
+	// Secrets are not stored, so a job run with secrets needs them sent again
+	var req JobRetryRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			reqLogger.Error().Err(err).Msg("Invalid request body")
+			c.JSON(400, gin.H{"error": "Invalid request body"})
+			return
+		}
+		if err := NewRequestValidator().ValidateJobRetryRequest(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if origJob.HasSecrets && len(req.Secrets) == 0 {
+		reqLogger.Warn().Msg("Retry of a job run with secrets sent without secrets")
+		c.JSON(400, gin.H{"error": "job was run with secrets; send them again as \"secrets\" in the retry request body"})
+		return
+	}
+
+	// By default a retry reruns the exact commit; latest=true takes the ref's current head
+	latest := c.Query("latest") == "true"
+
 	reqLogger.Info().
 		Str("original_status", origJob.Status).
 		Int("original_retry_count", origJob.RetryCount).
+		Str("ref", origJob.Ref).
+		Str("commit", origJob.CommitSHA).
+		Bool("latest", latest).
 		Msg("Creating retry job")
 
-	newJob := s.createRetryJob(origJob)
+	newJob := s.createRetryJob(origJob, latest)
+	newJob.RequestedBy = principal(c).Name
+	newJob.HasSecrets = len(req.Secrets) > 0
+	s.JobProcessor.SetSecrets(newJob.ID, req.Secrets)
 	s.queueJob(newJob)
 
+	event := jobAuditEvent("job.retry", newJob)
+	event.Detail = "retry of " + jobID
+	s.recordAudit(c, event)
+
 	reqLogger.Info().
 		Str("new_job_id", newJob.ID).
 		Int("new_retry_count", newJob.RetryCount).
 		Msg("Retry job created and queued")
 
-	c.JSON(202, gin.H{"status": "queued", "job_id": newJob.ID, "retry_of": jobID})
+	c.JSON(202, gin.H{"status": "queued", "job_id": newJob.ID, "retry_of": jobID, "ref": newJob.Ref, "commit_sha": newJob.CommitSHA})
 }
 
-func (s *Server) createRetryJob(origJob *Job) *Job {
+func (s *Server) handleJobCancel(c *gin.Context) {
+	jobID := c.Param("job_id")
+
+	reqLogger := s.Logger.With().
+		Str("job_id", jobID).
+		Str("endpoint", "/api/jobs/:job_id/cancel").
+		Str("method", c.Request.Method).
+		Str("remote_addr", c.ClientIP()).
+		Logger()
+
+	reqLogger.Info().Msg("Job cancel request received")
+
+	if existing, exists := s.JobStore.Get(jobID); exists && !s.authorizeTarget(c, existing.RepositoryURL, existing.PlaybookPath) {
+		return
+	}
+
+	job, err := s.JobProcessor.Cancel(jobID)
+	switch {
+	case errors.Is(err, errJobNotFound):
+		// Drift remediations are not jobs but are cancellable by their remediation
+		// ID, subject to the allow-list of their drift entry
+		if entry, entryErr := s.Drift.RemediationEntry(jobID); entryErr == nil {
+			if !s.authorizeTarget(c, entry.Repo, entry.Playbook) {
+				return
+			}
+			if s.Tasks.Cancel(jobID, false) {
+				s.recordAudit(c, audit.Event{
+					Action:        "drift.remediation.cancel",
+					JobID:         jobID,
+					RepositoryURL: entry.Repo,
+					PlaybookPath:  entry.Playbook,
+					Ref:           entry.Ref,
+					TargetHosts:   entry.TargetHosts,
+					Status:        "cancelling",
+					Detail:        entry.ID,
+				})
+				reqLogger.Info().Str("drift_id", entry.ID).Msg("Cancellation sent to drift remediation")
+				c.JSON(202, gin.H{"status": "cancelling", "job_id": jobID})
+				return
+			}
+		}
+		reqLogger.Warn().Msg("Job not found for cancel")
+		c.JSON(404, gin.H{"error": "Job not found"})
+		return
+	case errors.Is(err, errJobNotCancellable):
+		reqLogger.Warn().Str("job_status", job.Status).Msg("Job cannot be cancelled")
+		c.JSON(409, gin.H{"error": err.Error(), "status": job.Status})
+		return
+	case err != nil:
+		reqLogger.Error().Err(err).Msg("Failed to cancel job")
+		c.JSON(500, gin.H{"error": "Failed to cancel job"})
+		return
+	}
+
+	s.recordAudit(c, jobAuditEvent("job.cancel", job))
+
+	if job.Status == "cancelling" {
+		c.JSON(202, gin.H{"status": job.Status, "job_id": jobID})
+		return
+	}
+	c.JSON(200, gin.H{"status": job.Status, "job_id": jobID})
+}
+
+func (s *Server) createRetryJob(origJob *Job, latest bool) *Job {
 	newJobID := fmt.Sprintf("job-%d", time.Now().UnixNano())
 	s.Logger.Debug().
 		Str("original_job_id", origJob.ID).
@@ -705,11 +1326,53 @@ func (s *Server) createRetryJob(origJob *Job) *Job {
 	newJob.EndTime = time.Time{}
 	newJob.Output = ""
 	newJob.Error = ""
+	newJob.Result = nil
 	newJob.RetryCount = origJob.RetryCount + 1
+	newJob.RetryOf = origJob.ID
+	newJob.WorkerID = 0
+	if latest {
+		newJob.CommitSHA = ""
+	}
 
 	return &newJob
 }
 
+func (s *Server) handleWorkers(c *gin.Context) {
+	c.JSON(200, s.JobProcessor.Status())
+}
+
+func (s *Server) handleWorkersResize(c *gin.Context) {
+	reqLogger := s.Logger.With().
+		Str("endpoint", "/api/workers").
+		Str("method", c.Request.Method).
+		Str("remote_addr", c.ClientIP()).
+		Logger()
+
+	var req WorkerPoolRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		reqLogger.Error().Err(err).Msg("Invalid request body")
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	validator := NewRequestValidator()
+	if err := validator.ValidateWorkerPoolRequest(&req); err != nil {
+		reqLogger.Error().Err(err).Int("count", req.Count).Msg("Worker pool request validation failed")
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	previous := s.JobProcessor.Resize(req.Count)
+	s.recordAudit(c, audit.Event{Action: "workers.resize", Detail: fmt.Sprintf("%d -> %d workers", previous, req.Count)})
+
+	reqLogger.Info().
+		Int("previous_workers", previous).
+		Int("workers", req.Count).
+		Msg("Worker pool resize requested")
+
+	c.JSON(200, s.JobProcessor.Status())
+}
+
 func (s *Server) Start() error {
 	s.Logger.Info().Str("addr", ":"+s.Config.ServerPort).Msg("Starting server")
 	return s.Router.Run(":" + s.Config.ServerPort)
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const jobFileExtension = ".json"
+
+// NewFileJobStore opens (or creates) a file-backed job store in the given directory
+// and loads all previously persisted jobs into memory.
+func NewFileJobStore(dir string) (*FileJobStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create job store directory %s: %w", dir, err)
+	}
+
+	store := &FileJobStore{
+		dir:    dir,
+		jobs:   make(map[string]*Job),
+		logger: log.With().Str("component", "job-store").Logger(),
+	}
+
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// load reads every persisted job file from the store directory
+func (s *FileJobStore) load() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read job store directory %s: %w", s.dir, err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), jobFileExtension) {
+			continue
+		}
+
+		path := filepath.Join(s.dir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			s.logger.Warn().Err(err).Str("path", path).Msg("Failed to read job file, skipping")
+			continue
+		}
+
+		var job Job
+		if err := json.Unmarshal(data, &job); err != nil {
+			s.logger.Warn().Err(err).Str("path", path).Msg("Failed to decode job file, skipping")
+			continue
+		}
+		s.jobs[job.ID] = &job
+	}
+
+	s.logger.Info().Str("dir", s.dir).Int("jobs", len(s.jobs)).Msg("Job store loaded")
+	return nil
+}
+
+// Save persists a snapshot of the job, replacing any previous version
+func (s *FileJobStore) Save(job *Job) error {
+	snapshot := *job
+
+	data, err := json.MarshalIndent(&snapshot, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode job %s: %w", job.ID, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := writeFileAtomic(s.jobPath(job.ID), data, 0600); err != nil {
+		return fmt.Errorf("failed to persist job %s: %w", job.ID, err)
+	}
+	s.jobs[job.ID] = &snapshot
+
+	return nil
+}
+
+// Get returns a copy of the job with the given ID
+func (s *FileJobStore) Get(id string) (*Job, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	job, exists := s.jobs[id]
+	if !exists {
+		return nil, false
+	}
+
+	snapshot := *job
+	return &snapshot, true
+}
+
+// List returns copies of all jobs ordered by start time
+func (s *FileJobStore) List() []*Job {
+	s.mu.RLock()
+	jobs := make([]*Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		snapshot := *job
+		jobs = append(jobs, &snapshot)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].StartTime.Before(jobs[j].StartTime)
+	})
+
+	return jobs
+}
+
+// Delete removes the job from memory and disk
+func (s *FileJobStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.jobs[id]; !exists {
+		return nil
+	}
+
+	if err := os.Remove(s.jobPath(id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete job %s: %w", id, err)
+	}
+	delete(s.jobs, id)
+
+	return nil
+}
+
+// jobPath returns the on-disk location of a job record
+func (s *FileJobStore) jobPath(id string) string {
+	return filepath.Join(s.dir, filepath.Base(id)+jobFileExtension)
+}
+
+// writeFileAtomic writes data to a temporary file and renames it into place so
+// readers never observe a partially written file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmpFile.Name()
+
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	if err := os.Chmod(tmpName, perm); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	return os.Rename(tmpName, path)
+}
+
+// recoverJobs re-queues jobs that were waiting when the service stopped and
+// marks jobs that were mid-execution as interrupted. Re-queued jobs that were
+// submitted with secrets fail when a worker picks them up, as their secrets
+// did not survive the restart.
+func (s *Server) recoverJobs() {
+	var requeue []*Job
+
+	for _, job := range s.JobStore.List() {
+		switch job.Status {
+		case "queued":
+			s.Streams.Open(job.ID)
+			requeue = append(requeue, job)
+		case "running":
+			job.Status = "interrupted"
+			job.Error = "job was interrupted by a service restart"
+			job.EndTime = time.Now()
+			if err := s.JobStore.Save(job); err != nil {
+				s.Logger.Error().Err(err).Str("job_id", job.ID).Msg("Failed to mark job as interrupted")
+				continue
+			}
+			s.recordJobFinished(job)
+			s.Logger.Warn().Str("job_id", job.ID).Msg("Marked job interrupted after restart")
+		}
+	}
+
+	if len(requeue) == 0 {
+		return
+	}
+
+	s.Logger.Info().Int("jobs", len(requeue)).Msg("Re-queuing jobs left over from previous run")
+
+	// The queue is bounded, so feed it from a goroutine to avoid blocking startup
+	go func() {
+		for _, job := range requeue {
+			s.enqueue(job)
+		}
+	}()
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"ansible-api/internal/githubapp"
+	"ansible-api/internal/metrics"
+	"time"
+)
+
+// maxPlaybookSeries bounds how many playbooks get their own series; further
+// playbooks are reported under the "other" label
+const maxPlaybookSeries = 100
+
+// driftCheckOutcomes and driftRemediationResults count the fixed label values of the drift metrics
+const (
+	driftCheckOutcomes      = 4
+	driftRemediationResults = 3
+)
+
+// NewServerMetrics registers the service's metrics. Gauges describing the
+// queue, workers and stored jobs are read from the server at scrape time.
+func NewServerMetrics(s *Server) *ServerMetrics {
+	r := metrics.NewRegistry()
+
+	m := &ServerMetrics{
+		Registry: r,
+		JobsFinished: r.NewCounterVec("ansible_api_jobs_finished_total",
+			"Jobs that reached a terminal status.", 0, "status"),
+		JobDuration: r.NewHistogramVec("ansible_api_job_duration_seconds",
+			"Execution time of jobs from worker pickup to completion.", metrics.DefaultBuckets, maxPlaybookSeries, "playbook"),
+		GithubTokenDuration: r.NewHistogramVec("ansible_api_github_token_duration_seconds",
+			"Latency of GitHub App installation token requests.", []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}, 0),
+		GithubTokenFailures: r.NewCounterVec("ansible_api_github_token_failures_total",
+			"Failed GitHub App installation token requests.", 0),
+		VaultFailures: r.NewCounterVec("ansible_api_vault_failures_total",
+			"Failed Vault calls by secret path.", 0, "path"),
+		DriftChecks: r.NewCounterVec("ansible_api_drift_checks_total",
+			"Drift checks by playbook and outcome (no_drift, drift_detected, skipped, error).", maxPlaybookSeries*driftCheckOutcomes, "playbook", "outcome"),
+		DriftDetected: r.NewGaugeVec("ansible_api_drift_detected",
+			"Whether the last drift check of a playbook found drift (1) or not (0).", maxPlaybookSeries, "playbook"),
+		DriftRemediations: r.NewCounterVec("ansible_api_drift_remediations_total",
+			"Drift remediations by playbook and result (success, failure, cancelled).", maxPlaybookSeries*driftRemediationResults, "playbook", "result"),
+	}
+
+	r.NewGaugeVecFunc("ansible_api_jobs", "Stored jobs by status.", "status", func() map[string]float64 {
+		counts := make(map[string]float64)
+		for _, job := range s.JobStore.List() {
+			counts[job.Status]++
+		}
+		return counts
+	})
+	r.NewGaugeFunc("ansible_api_job_queue_depth", "Jobs waiting in the queue.", func() float64 {
+		return float64(len(s.JobQueue))
+	})
+	r.NewGaugeFunc("ansible_api_job_queue_capacity", "Capacity of the job queue.", func() float64 {
+		return float64(cap(s.JobQueue))
+	})
+	r.NewGaugeFunc("ansible_api_workers", "Job workers in the pool.", func() float64 {
+		return float64(s.JobProcessor.Status().Workers)
+	})
+	r.NewGaugeFunc("ansible_api_workers_busy", "Job workers currently running a job.", func() float64 {
+		return float64(s.JobProcessor.Status().Busy)
+	})
+	r.NewGaugeFunc("ansible_api_worker_utilization", "Fraction of job workers currently busy.", func() float64 {
+		status := s.JobProcessor.Status()
+		if status.Workers == 0 {
+			return 0
+		}
+		return float64(status.Busy) / float64(status.Workers)
+	})
+
+	return m
+}
+
+// githubToken fetches a GitHub App installation token, recording its latency and failures
+func (s *Server) githubToken() (string, error) {
+	start := time.Now()
+	token, err := (&githubapp.DefaultAuthenticator{}).GetInstallationToken(githubapp.AuthConfig{
+		AppID:          s.GithubAppID,
+		InstallationID: s.GithubInstallationID,
+		PrivateKey:     s.GithubPrivateKey,
+		APIBaseURL:     s.GithubAPIBaseURL,
+	})
+
+	if s.Metrics != nil {
+		s.Metrics.GithubTokenDuration.Observe(time.Since(start).Seconds())
+		if err != nil {
+			s.Metrics.GithubTokenFailures.Inc()
+		}
+	}
+
+	return token, err
+}
+
+// recordDriftCheck records the outcome of a drift check and any remediation it
+// triggered. Only checks that ran update the drift gauge; a skipped check
+// knows nothing new about the hosts.
+func (s *Server) recordDriftCheck(playbook, outcome, remediationStatus string, remediated bool) {
+	if s.Metrics == nil {
+		return
+	}
+
+	s.Metrics.DriftChecks.Inc(playbook, outcome)
+	switch outcome {
+	case "drift_detected":
+		s.Metrics.DriftDetected.Set(1, playbook)
+	case "no_drift":
+		s.Metrics.DriftDetected.Set(0, playbook)
+	}
+
+	if remediated {
+		s.recordDriftRemediation(playbook, remediationStatus)
+	}
+}
+
+// recordDriftRemediation records the result of a drift remediation, run by
+// the detector ("ok") or as an approved job ("completed")
+func (s *Server) recordDriftRemediation(playbook, status string) {
+	if s.Metrics == nil {
+		return
+	}
+
+	result := "failure"
+	switch status {
+	case "ok", "completed":
+		result = "success"
+	case "cancelled":
+		result = "cancelled"
+	}
+	s.Metrics.DriftRemediations.Inc(playbook, result)
+}
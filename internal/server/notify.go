@@ -0,0 +1,293 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Notification event types
+const (
+	eventJobCompleted           = "job.completed"
+	eventJobFailed              = "job.failed"
+	eventDriftDetected          = "drift.detected"
+	eventDriftRemediated        = "drift.remediated"
+	eventDriftRemediationFailed = "drift.remediation_failed"
+)
+
+// maxNotificationChangesPerHost bounds the drift changes listed per host in an event
+const maxNotificationChangesPerHost = 20
+
+// notificationEventTypes lists every event a target can subscribe to
+var notificationEventTypes = []string{
+	eventJobCompleted,
+	eventJobFailed,
+	eventDriftDetected,
+	eventDriftRemediated,
+	eventDriftRemediationFailed,
+}
+
+// NewNotifications creates a dispatcher for the given notifiers
+func NewNotifications(notifiers ...Notifier) *Notifications {
+	return &Notifications{
+		notifiers: notifiers,
+		logger:    log.With().Str("component", "notifications").Logger(),
+	}
+}
+
+// Publish hands an event to every notifier
+func (n *Notifications) Publish(event NotificationEvent) {
+	if n == nil || len(n.notifiers) == 0 {
+		return
+	}
+
+	n.logger.Debug().Str("event", event.Type).Str("event_id", event.ID).Msg("Publishing notification")
+	for _, notifier := range n.notifiers {
+		notifier.Notify(event)
+	}
+}
+
+// DriftCycleFinished tells notifiers that batch drift events that a drift cycle has finished
+func (n *Notifications) DriftCycleFinished(cycle string) {
+	if n == nil {
+		return
+	}
+	for _, notifier := range n.notifiers {
+		if cycleNotifier, ok := notifier.(DriftCycleNotifier); ok {
+			cycleNotifier.DriftCycleFinished(cycle)
+		}
+	}
+}
+
+// driftCycleFinished reports the end of a drift cycle to the server's notifiers
+func (s *Server) driftCycleFinished(cycle string) {
+	if s == nil || s.Notifications == nil {
+		return
+	}
+	s.Notifications.DriftCycleFinished(cycle)
+}
+
+// notify publishes an event to the server's notifiers, if any are configured
+func (s *Server) notify(event NotificationEvent) {
+	if s == nil || s.Notifications == nil {
+		return
+	}
+	s.Notifications.Publish(event)
+}
+
+func newNotificationEvent(eventType, summary string) NotificationEvent {
+	now := time.Now().UTC()
+	return NotificationEvent{
+		ID:      fmt.Sprintf("evt-%d", now.UnixNano()),
+		Type:    eventType,
+		Time:    now,
+		Summary: summary,
+	}
+}
+
+// jobNotificationEvent describes a finished job. Only completed and failed
+// (including timed out) jobs are notified; ok is false for any other status.
+func jobNotificationEvent(job *Job) (NotificationEvent, bool) {
+	var eventType, outcome string
+	switch job.Status {
+	case "completed":
+		eventType, outcome = eventJobCompleted, "completed"
+	case "failed":
+		eventType, outcome = eventJobFailed, "failed"
+	case "timed_out":
+		eventType, outcome = eventJobFailed, "timed out"
+	default:
+		return NotificationEvent{}, false
+	}
+
+	event := newNotificationEvent(eventType, fmt.Sprintf("Job %s %s: %s", job.ID, outcome, job.PlaybookPath))
+	event.Job = &JobNotification{
+		ID:            job.ID,
+		Status:        job.Status,
+		RepositoryURL: job.RepositoryURL,
+		PlaybookPath:  job.PlaybookPath,
+		Ref:           job.Ref,
+		CommitSHA:     job.CommitSHA,
+		TargetHosts:   job.TargetHosts,
+		RequestedBy:   job.RequestedBy,
+		Error:         job.Error,
+		StartTime:     job.StartTime,
+		EndTime:       job.EndTime,
+		RemediationOf: job.RemediationOf,
+		DriftID:       job.DriftID,
+	}
+	if job.Result != nil {
+		event.Job.Recap = job.Result.Stats
+	}
+	return event, true
+}
+
+// driftNotificationEvent describes a drift check of an entry. Ignored changes
+// and the file contents of the others are left out.
+func driftNotificationEvent(eventType, id string, state PlaybookState, result DriftCheckResult) NotificationEvent {
+	var summary string
+	switch eventType {
+	case eventDriftDetected:
+		summary = fmt.Sprintf("Drift detected on %s (%s)", state.Playbook, result.Status)
+	case eventDriftRemediated:
+		summary = fmt.Sprintf("Drift remediated on %s", state.Playbook)
+	default:
+		summary = fmt.Sprintf("Drift remediation failed on %s (%s)", state.Playbook, result.Status)
+	}
+
+	event := newNotificationEvent(eventType, summary)
+	event.Drift = &DriftNotification{
+		ID:            id,
+		Playbook:      state.Playbook,
+		Repo:          state.Repo,
+		Ref:           state.Ref,
+		Commit:        result.Commit,
+		TargetHosts:   state.TargetHosts,
+		Status:        result.Status,
+		Trigger:       result.Trigger,
+		RemediationID: result.RemediationID,
+	}
+
+	perHost := make(map[string]int)
+	for _, change := range result.Changes {
+		if change.IgnoredBy != "" || perHost[change.Host] >= maxNotificationChangesPerHost {
+			continue
+		}
+		perHost[change.Host]++
+		change.Files = nil
+		event.Drift.Changes = append(event.Drift.Changes, change)
+	}
+	return event
+}
+
+// driftChanged reports whether a check found drift that the previous check
+// of the entry did not report: new hosts, tasks or diffs
+func driftChanged(previous PlaybookState, changes []DriftChange) bool {
+	if !previous.DriftDetected {
+		return true
+	}
+
+	seen := make(map[string]bool)
+	for _, change := range previous.LastChanges {
+		if change.IgnoredBy == "" {
+			seen[change.Host+"\x00"+change.Task+"\x00"+change.Diff] = true
+		}
+	}
+	for _, change := range changes {
+		if change.IgnoredBy == "" && !seen[change.Host+"\x00"+change.Task+"\x00"+change.Diff] {
+			return true
+		}
+	}
+	return false
+}
+
+// eventFact is a labelled value shown in chat and email notifications
+type eventFact struct {
+	Name  string
+	Value string
+}
+
+// eventFacts lists the details of an event for human-readable notifications
+func eventFacts(event NotificationEvent) []eventFact {
+	var facts []eventFact
+	add := func(name, value string) {
+		if value != "" {
+			facts = append(facts, eventFact{name, value})
+		}
+	}
+
+	if job := event.Job; job != nil {
+		add("Job", job.ID)
+		add("Status", job.Status)
+		add("Repository", job.RepositoryURL)
+		add("Playbook", job.PlaybookPath)
+		add("Ref", job.Ref)
+		add("Commit", job.CommitSHA)
+		add("Target hosts", job.TargetHosts)
+		add("Requested by", job.RequestedBy)
+		add("Remediation of", job.RemediationOf)
+		add("Error", job.Error)
+		if !job.EndTime.IsZero() && !job.StartTime.IsZero() {
+			add("Duration", job.EndTime.Sub(job.StartTime).Round(time.Second).String())
+		}
+	}
+
+	if drift := event.Drift; drift != nil {
+		add("Drift entry", drift.ID)
+		add("Status", drift.Status)
+		add("Repository", drift.Repo)
+		add("Playbook", drift.Playbook)
+		add("Ref", drift.Ref)
+		add("Commit", drift.Commit)
+		add("Target hosts", drift.TargetHosts)
+		add("Remediation", drift.RemediationID)
+		if len(drift.Changes) > 0 {
+			hosts := make(map[string]bool)
+			for _, change := range drift.Changes {
+				hosts[change.Host] = true
+			}
+			add("Changes", fmt.Sprintf("%d on %d host(s)", len(drift.Changes), len(hosts)))
+		}
+	}
+	return facts
+}
+
+// eventTarget returns the repository and playbook of an event's job or drift entry
+func eventTarget(event NotificationEvent) (string, string) {
+	switch {
+	case event.Job != nil:
+		return event.Job.RepositoryURL, event.Job.PlaybookPath
+	case event.Drift != nil:
+		return event.Drift.Repo, event.Drift.Playbook
+	}
+	return "", ""
+}
+
+// eventFailed reports whether an event is about a failure
+func eventFailed(event NotificationEvent) bool {
+	return event.Type == eventJobFailed || event.Type == eventDriftRemediationFailed
+}
+
+// recapLines renders play recap counters the way ansible-playbook prints them
+func recapLines(stats map[string]HostStats) []string {
+	hosts := make([]string, 0, len(stats))
+	for host := range stats {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	lines := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		s := stats[host]
+		lines = append(lines, fmt.Sprintf("%s : ok=%d changed=%d unreachable=%d failed=%d skipped=%d rescued=%d ignored=%d",
+			host, s.Ok, s.Changed, s.Unreachable, s.Failures, s.Skipped, s.Rescued, s.Ignored))
+	}
+	return lines
+}
+
+// driftChangeLines lists drift changes as "host: task (path)"
+func driftChangeLines(changes []DriftChange) []string {
+	lines := make([]string, 0, len(changes))
+	for _, change := range changes {
+		line := change.Host + ": " + change.Task
+		if change.Path != "" {
+			line += " (" + change.Path + ")"
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// eventDetail is the recap of a job event or the changes of a drift event
+func eventDetail(event NotificationEvent) string {
+	switch {
+	case event.Job != nil && len(event.Job.Recap) > 0:
+		return strings.Join(recapLines(event.Job.Recap), "\n")
+	case event.Drift != nil && len(event.Drift.Changes) > 0:
+		return strings.Join(driftChangeLines(event.Drift.Changes), "\n")
+	}
+	return ""
+}
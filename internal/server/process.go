@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// processTerminationGrace is how long a process group gets to exit after
+// SIGTERM before it is killed with SIGKILL
+const processTerminationGrace = 10 * time.Second
+
+var (
+	// errJobCancelled is the cancellation cause used when an operator cancels a task
+	errJobCancelled = errors.New("cancelled by request")
+	// errJobTimedOut is the cancellation cause used when a job exceeds its deadline
+	errJobTimedOut = errors.New("execution deadline exceeded")
+)
+
+// runProcess starts cmd in its own process group and waits for it to exit.
+// If ctx is done first the whole group receives SIGTERM and, after a grace
+// period, SIGKILL, so ansible forks and ssh children are cleaned up too.
+// Output already written to cmd.Stdout/cmd.Stderr is preserved.
+func runProcess(ctx context.Context, cmd *exec.Cmd) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+
+	pgid := cmd.Process.Pid
+	_ = syscall.Kill(-pgid, syscall.SIGTERM)
+
+	select {
+	case <-done:
+	case <-time.After(processTerminationGrace):
+		_ = syscall.Kill(-pgid, syscall.SIGKILL)
+		<-done
+	}
+
+	return context.Cause(ctx)
+}
+
+// NewTaskRegistry creates an empty registry of cancellable tasks
+func NewTaskRegistry() *TaskRegistry {
+	return &TaskRegistry{
+		running: make(map[string]context.CancelCauseFunc),
+		pending: make(map[string]bool),
+	}
+}
+
+// Begin registers a task as running. It returns false if the task was
+// cancelled before it started, in which case the caller must not run it.
+func (r *TaskRegistry) Begin(id string, cancel context.CancelCauseFunc) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.pending[id] {
+		delete(r.pending, id)
+		return false
+	}
+	r.running[id] = cancel
+	return true
+}
+
+// End removes a finished task from the registry, including a cancellation
+// that arrived after it had already finished
+func (r *TaskRegistry) End(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.running, id)
+	delete(r.pending, id)
+}
+
+// Forget drops the pending cancellation of a task that will never start
+func (r *TaskRegistry) Forget(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.pending, id)
+}
+
+// Cancel cancels a running task and reports whether it was running. When
+// allowPending is set and the task has not started yet, it is marked so that
+// Begin refuses to start it.
+func (r *TaskRegistry) Cancel(id string, allowPending bool) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if cancel, ok := r.running[id]; ok {
+		cancel(errJobCancelled)
+		return true
+	}
+	if allowPending {
+		r.pending[id] = true
+	}
+	return false
+}
+
+// IsRunning reports whether a task is currently registered as running
+func (r *TaskRegistry) IsRunning(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.running[id]
+	return ok
+}
@@ -3,51 +3,172 @@ package server
 import (
 	"ansible-api/internal/githubapp"
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
+)
 
-	"github.com/rs/zerolog"
-	"gopkg.in/src-d/go-git.v4"
+var (
+	errJobNotFound       = errors.New("job not found")
+	errJobNotCancellable = errors.New("job is not queued or running")
 )
 
 func NewJobProcessor(server *Server) *JobProcessor {
 	return &JobProcessor{
-		server: server,
+		server:  server,
+		workers: make(map[int]*jobWorker),
+		secrets: make(map[string]map[string]string),
 	}
 }
 
-// ProcessJobs continuously processes jobs from the queue
-func (p *JobProcessor) ProcessJobs() {
-	for job := range p.server.JobQueue {
-		p.processJob(job)
+// Start launches the given number of workers pulling from the job queue
+func (p *JobProcessor) Start(count int) {
+	p.Resize(count)
+}
+
+// Resize grows or shrinks the worker pool to the given size. Workers that are
+// stopped finish their current job before exiting.
+func (p *JobProcessor) Resize(count int) int {
+	if count < 1 {
+		count = 1
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	previous := len(p.workers)
+	for len(p.workers) < count {
+		p.nextWorkerID++
+		w := &jobWorker{id: p.nextWorkerID, stop: make(chan struct{})}
+		p.workers[w.id] = w
+		go p.runWorker(w)
+	}
+
+	if len(p.workers) > count {
+		// Stop the most recently started workers first
+		ids := make([]int, 0, len(p.workers))
+		for id := range p.workers {
+			ids = append(ids, id)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(ids)))
+		for _, id := range ids[:len(p.workers)-count] {
+			close(p.workers[id].stop)
+			delete(p.workers, id)
+		}
+	}
+
+	if p.server.Config != nil {
+		p.server.Config.WorkerCount = count
+	}
+
+	p.server.Logger.Info().
+		Int("previous_workers", previous).
+		Int("workers", count).
+		Msg("Job worker pool resized")
+
+	return previous
+}
+
+// Status returns a snapshot of the worker pool and queue utilization
+func (p *JobProcessor) Status() WorkerPoolStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return WorkerPoolStatus{
+		Workers:       len(p.workers),
+		Busy:          p.busy,
+		QueueLength:   len(p.server.JobQueue),
+		QueueCapacity: cap(p.server.JobQueue),
 	}
 }
 
-func (p *JobProcessor) processJob(job *Job) {
+// runWorker processes jobs from the queue until the worker is stopped
+func (p *JobProcessor) runWorker(w *jobWorker) {
+	workerLogger := p.server.Logger.With().Int("worker_id", w.id).Logger()
+	workerLogger.Info().Msg("Job worker started")
+
+	for {
+		select {
+		case <-w.stop:
+			workerLogger.Info().Msg("Job worker stopped")
+			return
+		case job, ok := <-p.server.JobQueue:
+			if !ok {
+				workerLogger.Info().Msg("Job queue closed, worker exiting")
+				return
+			}
+			p.setBusy(1)
+			p.processJob(w.id, job)
+			p.setBusy(-1)
+		}
+	}
+}
+
+func (p *JobProcessor) setBusy(delta int) {
+	p.mu.Lock()
+	p.busy += delta
+	p.mu.Unlock()
+}
+
+func (p *JobProcessor) processJob(workerID int, job *Job) {
 	// Create a logger with job context for this entire job execution
 	jobLogger := p.server.Logger.With().
+		Int("worker_id", workerID).
 		Str("job_id", job.ID).
 		Str("repository", job.RepositoryURL).
 		Str("playbook", job.PlaybookPath).
 		Str("target_hosts", job.TargetHosts).
 		Logger()
 
+	// Secrets are handed over once and never stored on the job
+	secrets := p.takeSecrets(job.ID)
+	redact := newRedactor(secrets)
+
+	// Register the job as cancellable; a job cancelled while queued is skipped
+	cancelCtx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	if !p.server.Tasks.Begin(job.ID, cancel) {
+		jobLogger.Info().Msg("Job was cancelled while queued, skipping")
+		return
+	}
+	defer p.server.Tasks.End(job.ID)
+
+	// Every step of the job (clone, collection install, playbook) shares one deadline
+	timeout := p.jobTimeout(job)
+	ctx, cancelTimeout := context.WithTimeoutCause(cancelCtx, timeout, errJobTimedOut)
+	defer cancelTimeout()
+	jobLogger = jobLogger.With().Dur("timeout", timeout).Logger()
+
+	// Secrets only live in memory; running without them would silently change the run
+	if job.HasSecrets && secrets == nil {
+		jobLogger.Error().Msg("Job secrets are no longer available")
+		p.failJob(ctx, job, errSecretsUnavailable.Error())
+		return
+	}
+
 	jobLogger.Info().Msg("Starting job processing")
 
 	p.server.JobMutex.Lock()
 	job.Status = "running"
+	job.WorkerID = workerID
 	p.server.JobMutex.Unlock()
+	p.server.saveJob(job)
 
 	// Track job duration
 	startTime := time.Now()
 	defer func() {
 		duration := time.Since(startTime)
+		p.server.Metrics.JobDuration.Observe(duration.Seconds(), job.PlaybookPath)
 		jobLogger.Info().
 			Dur("duration", duration).
 			Str("final_status", job.Status).
@@ -57,7 +178,7 @@ func (p *JobProcessor) processJob(job *Job) {
 	tmpDir, err := os.MkdirTemp("", "repo")
 	if err != nil {
 		jobLogger.Error().Err(err).Msg("Failed to create temporary directory")
-		p.updateJobStatus(job, "failed", "", err.Error())
+		p.failJob(ctx, job, err.Error())
 		return
 	}
 	defer func() {
@@ -70,12 +191,7 @@ func (p *JobProcessor) processJob(job *Job) {
 
 	jobLogger.Info().Msg("Authenticating with GitHub App")
 
-	token, err := (&githubapp.DefaultAuthenticator{}).GetInstallationToken(githubapp.AuthConfig{
-		AppID:          p.server.GithubAppID,
-		InstallationID: p.server.GithubInstallationID,
-		PrivateKey:     p.server.GithubPrivateKey,
-		APIBaseURL:     p.server.GithubAPIBaseURL,
-	})
+	token, err := p.server.githubToken()
 	if err != nil {
 		jobLogger.Error().
 			Err(err).
@@ -83,7 +199,7 @@ func (p *JobProcessor) processJob(job *Job) {
 			Int("installation_id", p.server.GithubInstallationID).
 			Str("api_base_url", p.server.GithubAPIBaseURL).
 			Msg("Failed to authenticate with GitHub")
-		p.updateJobStatus(job, "failed", "", "GitHub App authentication failed: "+err.Error())
+		p.failJob(ctx, job, "GitHub App authentication failed: "+err.Error())
 		return
 	}
 
@@ -93,29 +209,41 @@ func (p *JobProcessor) processJob(job *Job) {
 	host := extractHost(job.RepositoryURL)
 	cloneURL := githubapp.BuildCloneURL(token, repoPath, host)
 
+	// Check out the pinned commit (retries) or the requested ref, and record
+	// the commit that actually runs
+	revision := job.CommitSHA
+	if revision == "" {
+		revision = job.Ref
+	}
+
 	jobLogger.Info().
 		Str("repository", repoPath).
 		Str("host", host).
 		Str("clone_url", maskTokenInURL(cloneURL)).
+		Str("revision", revision).
 		Str("tmp_dir", tmpDir).
-		Msg("Cloning repository")
+		Msg("Checking out repository")
 
-	gitOutput := &gitOutputWriter{logger: jobLogger.With().Str("component", "git").Logger()}
-	_, err = git.PlainClone(tmpDir, false, &git.CloneOptions{
-		URL:      cloneURL,
-		Progress: gitOutput,
-	})
+	commitSHA, releaseCheckout, err := p.server.RepoCache.Checkout(ctx, job.RepositoryURL, cloneURL, revision, tmpDir)
 	if err != nil {
 		jobLogger.Error().
 			Err(err).
 			Str("repository", repoPath).
-			Str("clone_url", maskTokenInURL(cloneURL)).
-			Msg("Failed to clone repository")
-		p.updateJobStatus(job, "failed", "", err.Error())
+			Str("ref", job.Ref).
+			Str("commit", job.CommitSHA).
+			Msg("Failed to check out repository")
+		p.failJob(ctx, job, err.Error())
 		return
 	}
+	defer releaseCheckout()
 
-	jobLogger.Info().Str("repository", repoPath).Msg("Repository cloned successfully")
+	p.server.JobMutex.Lock()
+	job.CommitSHA = commitSHA
+	p.server.JobMutex.Unlock()
+	p.server.saveJob(job)
+
+	jobLogger = jobLogger.With().Str("commit", commitSHA).Logger()
+	jobLogger.Info().Str("ref", job.Ref).Msg("Checked out playbook revision")
 
 	// Inventory handling with detailed logging
 	inventoryFilePath := filepath.Join(tmpDir, "inventory", "hosts.ini")
@@ -127,46 +255,41 @@ func (p *JobProcessor) processJob(job *Job) {
 			Str("fallback_inventory", fallbackInventoryFilePath).
 			Msg("No inventory provided in request, checking repository")
 
+		// The inventory file used is recorded so drift checks reuse it
+		inventoryFile := filepath.Join("inventory", "hosts.ini")
 		if _, err := os.Stat(inventoryFilePath); os.IsNotExist(err) {
 			if _, err := os.Stat(fallbackInventoryFilePath); os.IsNotExist(err) {
 				jobLogger.Error().
 					Str("primary_inventory", inventoryFilePath).
 					Str("fallback_inventory", fallbackInventoryFilePath).
 					Msg("No inventory file found in repository and no inventory provided in request")
-				p.updateJobStatus(job, "failed", "", "No inventory file found in repository and no inventory provided in request")
+				p.failJob(ctx, job, "No inventory file found in repository and no inventory provided in request")
 				return
 			} else {
 				inventoryFilePath = fallbackInventoryFilePath
+				if inventoryFile, err = filepath.Abs(fallbackInventoryFilePath); err != nil {
+					inventoryFile = fallbackInventoryFilePath
+				}
 				jobLogger.Info().Str("inventory_path", inventoryFilePath).Msg("Using fallback inventory file")
 			}
 		} else {
 			jobLogger.Info().Str("inventory_path", inventoryFilePath).Msg("Using repository inventory file")
 		}
+
+		p.server.JobMutex.Lock()
+		job.InventoryFile = inventoryFile
+		p.server.JobMutex.Unlock()
 	} else {
 		jobLogger.Info().
 			Int("inventory_groups", len(job.Inventory)).
 			Str("inventory_path", inventoryFilePath).
 			Msg("Creating inventory file from request")
 
-		inventoryFile, err := os.Create(inventoryFilePath)
-		if err != nil {
+		if err := writeInventoryFile(inventoryFilePath, job.Inventory); err != nil {
 			jobLogger.Error().Err(err).Str("inventory_path", inventoryFilePath).Msg("Failed to create inventory file")
-			p.updateJobStatus(job, "failed", "", err.Error())
+			p.failJob(ctx, job, err.Error())
 			return
 		}
-		defer inventoryFile.Close()
-
-		for group, hosts := range job.Inventory {
-			jobLogger.Debug().
-				Str("group", group).
-				Int("hosts", len(hosts)).
-				Msg("Writing inventory group")
-			fmt.Fprintf(inventoryFile, "[%s]\n", group)
-			for host, vars := range hosts {
-				fmt.Fprintf(inventoryFile, "%s %s\n", host, vars)
-			}
-			fmt.Fprintf(inventoryFile, "\n")
-		}
 		jobLogger.Info().Msg("Inventory file created successfully")
 	}
 
@@ -196,14 +319,18 @@ func (p *JobProcessor) processJob(job *Job) {
 			"ANSIBLE_HOST_KEY_CHECKING=False",
 		)
 
-		if collectionsOutput, err := collectionsCmd.CombinedOutput(); err != nil {
+		var collectionsOutput bytes.Buffer
+		collectionsCmd.Stdout = &collectionsOutput
+		collectionsCmd.Stderr = &collectionsOutput
+
+		if err := runProcess(ctx, collectionsCmd); err != nil {
 			jobLogger.Warn().
 				Err(err).
-				Str("output", string(collectionsOutput)).
+				Str("output", collectionsOutput.String()).
 				Msg("Failed to install collections, proceeding anyway")
 		} else {
 			jobLogger.Info().
-				Str("output", string(collectionsOutput)).
+				Str("output", collectionsOutput.String()).
 				Msg("Collections installed successfully")
 		}
 	} else {
@@ -263,6 +390,15 @@ func (p *JobProcessor) processJob(job *Job) {
 		ansibleCmd.Args = append(ansibleCmd.Args, "--limit", job.TargetHosts)
 	}
 
+	// Request options (extra vars, tags, check/diff mode, ...)
+	optionArgs, err := playbookOptionArgs(job.RunOptions)
+	if err != nil {
+		jobLogger.Error().Err(err).Msg("Invalid playbook options")
+		p.failJob(ctx, job, err.Error())
+		return
+	}
+	ansibleCmd.Args = append(ansibleCmd.Args, optionArgs...)
+
 	// Add SSH key if available (fallback option)
 	if sshKeyPath != "" {
 		ansibleCmd.Args = append(ansibleCmd.Args, "--private-key", sshKeyPath)
@@ -272,6 +408,19 @@ func (p *JobProcessor) processJob(job *Job) {
 	}
 	ansibleCmd.Dir = tmpDir
 
+	// Deliver secrets as a private extra-vars file removed when the job ends
+	if len(secrets) > 0 {
+		secretsFile, err := writeSecretsFile(secrets)
+		if err != nil {
+			jobLogger.Error().Err(err).Msg("Failed to write secrets file")
+			p.failJob(ctx, job, err.Error())
+			return
+		}
+		defer os.Remove(secretsFile)
+		ansibleCmd.Args = append(ansibleCmd.Args, "--extra-vars", "@"+secretsFile)
+		jobLogger.Info().Int("secrets", len(secrets)).Msg("Added secrets extra-vars file")
+	}
+
 	// Log the full command being executed
 	jobLogger.Info().
 		Strs("command_args", ansibleCmd.Args).
@@ -285,6 +434,19 @@ func (p *JobProcessor) processJob(job *Job) {
 		"ANSIBLE_ROLES_PATH=./roles:./playbooks/roles:~/.ansible/roles:/usr/share/ansible/roles:/etc/ansible/roles",
 	)
 
+	// Request-provided environment. Names that control the server's processes
+	// are rejected at validation, and it is added before credentials so it
+	// cannot override them.
+	if len(job.Environment) > 0 {
+		ansibleCmd.Env = append(ansibleCmd.Env, requestEnvironment(job.Environment)...)
+		names := make([]string, 0, len(job.Environment))
+		for name := range job.Environment {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		jobLogger.Info().Strs("environment_vars", names).Msg("Added request environment variables")
+	}
+
 	// Pass SSH credentials from Vault via environment variables (air-gapped friendly)
 	if p.server.VaultClient != nil {
 		if credentials, err := p.server.VaultClient.GetSecret("ansible/credentials"); err == nil {
@@ -348,59 +510,265 @@ func (p *JobProcessor) processJob(job *Job) {
 		jobLogger.Info().Str("kerberos_user", kerberosUser).Msg("Using ANSIBLE_REMOTE_USER environment variable")
 	}
 
-	// Capture output
+	// Collect structured results through the callback plugin while the default
+	// stdout callback keeps producing human-readable output for streaming
+	resultFile := ""
+	if callbackDir, err := os.MkdirTemp("", "ansible-api-callback-"); err != nil {
+		jobLogger.Warn().Err(err).Msg("Failed to create callback directory, structured results disabled")
+	} else {
+		defer os.RemoveAll(callbackDir)
+		callbackEnv, path, err := prepareResultCallback(callbackDir)
+		if err != nil {
+			jobLogger.Warn().Err(err).Msg("Failed to install result callback, structured results disabled")
+		} else {
+			ansibleCmd.Env = append(ansibleCmd.Env, callbackEnv...)
+			resultFile = path
+		}
+	}
+
+	// Capture output and stream it line by line to live subscribers
 	var stdout, stderr bytes.Buffer
-	ansibleCmd.Stdout = &stdout
-	ansibleCmd.Stderr = &stderr
+	stream := p.server.Streams.Open(job.ID)
+	stdoutLines := newLineWriter(func(line string) { stream.Write("stdout", redact(line)) })
+	stderrLines := newLineWriter(func(line string) { stream.Write("stderr", redact(line)) })
+	ansibleCmd.Stdout = io.MultiWriter(&stdout, stdoutLines)
+	ansibleCmd.Stderr = io.MultiWriter(&stderr, stderrLines)
+
+	if ctx.Err() != nil {
+		p.failJob(ctx, job, "job stopped before playbook execution")
+		return
+	}
 
 	jobLogger.Info().Msg("Executing Ansible playbook")
-	err = ansibleCmd.Run()
+	err = runProcess(ctx, ansibleCmd)
+	stdoutLines.Flush()
+	stderrLines.Flush()
+
+	// Capture the raw output with secret values masked
+	rawOutput := redact(stdout.String())
+	rawError := redact(stderr.String())
+
+	// Load structured results and render the text report from them
+	var result *PlaybookResult
+	if resultFile != "" {
+		if loaded, loadErr := loadPlaybookResult(resultFile, redact); loadErr != nil {
+			jobLogger.Warn().Err(loadErr).Msg("Structured playbook result unavailable")
+		} else {
+			result = loaded
+		}
+	}
+	structuredOutput := renderTextReport(result, rawOutput, rawError, err)
 
-	// Capture the raw output
-	rawOutput := stdout.String()
-	rawError := stderr.String()
+	p.server.JobMutex.Lock()
+	job.Result = result
+	p.server.JobMutex.Unlock()
 
-	// Create structured output
-	structuredOutput := p.createStructuredOutput(rawOutput, rawError, err)
+	duration := time.Since(job.StartTime)
 
-	job.EndTime = time.Now()
-	duration := job.EndTime.Sub(job.StartTime)
+	if cause := context.Cause(ctx); cause != nil {
+		jobLogger.Warn().
+			Err(cause).
+			Dur("duration", duration).
+			Msg("Ansible playbook execution stopped")
+		p.updateJobStatus(job, stoppedJobStatus(ctx), structuredOutput, cause.Error())
+		return
+	}
 
 	if err != nil {
-		job.Status = "failed"
-		job.Error = err.Error()
 		jobLogger.Error().
 			Err(err).
 			Str("raw_output", rawOutput).
 			Str("raw_error", rawError).
 			Dur("duration", duration).
 			Msg("Ansible playbook execution failed")
+		p.updateJobStatus(job, "failed", structuredOutput, err.Error())
 	} else {
-		job.Status = "completed"
 		jobLogger.Info().
 			Dur("duration", duration).
 			Msg("Ansible playbook execution completed successfully")
+		p.updateJobStatus(job, "completed", structuredOutput, "")
 	}
 
-	job.Output = structuredOutput
-
-	// Record completed state
-	logicalPlaybookPath := job.PlaybookPath
-	if updateErr := UpdatePlaybookState(p.server, logicalPlaybookPath, playbookPath, job.RepositoryURL, job.Status, job.TargetHosts); updateErr != nil {
+	// Record completed state. Check mode runs changed nothing and failed runs
+	// are no baseline, so neither is tracked; remediation jobs always report back.
+	if job.CheckMode || (job.Status != "completed" && job.RemediationOf == "") {
+		jobLogger.Debug().Bool("check_mode", job.CheckMode).Msg("Job not tracked for drift detection")
+		return
+	}
+	if updateErr := UpdatePlaybookState(p.server, job, playbookPath); updateErr != nil {
 		jobLogger.Error().Err(updateErr).Msg("Failed to update playbook state")
 	}
 }
 
+// failJob records a job that ended before or during execution, reporting
+// operator cancellation distinctly from failures
+func (p *JobProcessor) failJob(ctx context.Context, job *Job, errMsg string) {
+	if cause := context.Cause(ctx); cause != nil {
+		p.updateJobStatus(job, stoppedJobStatus(ctx), "", cause.Error())
+		return
+	}
+	p.updateJobStatus(job, "failed", "", errMsg)
+}
+
+// stoppedJobStatus maps the cause of a stopped job context to a job status
+func stoppedJobStatus(ctx context.Context) string {
+	switch cause := context.Cause(ctx); {
+	case errors.Is(cause, errJobCancelled):
+		return "cancelled"
+	case errors.Is(cause, errJobTimedOut):
+		return "timed_out"
+	}
+	return "failed"
+}
+
+// jobTimeout returns the execution deadline for a job, falling back to the server default
+func (p *JobProcessor) jobTimeout(job *Job) time.Duration {
+	if job.TimeoutSeconds > 0 {
+		return time.Duration(job.TimeoutSeconds) * time.Second
+	}
+	if p.server.Config != nil && p.server.Config.JobTimeoutSeconds > 0 {
+		return time.Duration(p.server.Config.JobTimeoutSeconds) * time.Second
+	}
+	return time.Hour
+}
+
+// Cancel cancels a queued or running job. Queued jobs are marked cancelled
+// and taken out of the queue, or skipped if a worker already dequeued them;
+// running jobs have their process group terminated and keep the output
+// captured so far.
+func (p *JobProcessor) Cancel(jobID string) (*Job, error) {
+	job, exists := p.server.JobStore.Get(jobID)
+	if !exists {
+		return nil, errJobNotFound
+	}
+	if job.Status != "queued" && job.Status != "running" {
+		return job, errJobNotCancellable
+	}
+
+	if p.server.Tasks.Cancel(jobID, true) {
+		p.server.Logger.Info().Str("job_id", jobID).Msg("Cancellation sent to running job")
+		job.Status = "cancelling"
+		return job, nil
+	}
+
+	// Not running: the job is still queued, or it finished since it was read
+	if current, ok := p.server.JobStore.Get(jobID); ok && current.Status != "queued" && current.Status != "running" {
+		p.server.Tasks.Forget(jobID)
+		return current, errJobNotCancellable
+	}
+
+	// A job taken out of the queue never starts; one a worker already
+	// dequeued is refused by the task registry
+	if p.server.removeQueuedJob(jobID) {
+		p.server.Tasks.Forget(jobID)
+	}
+	p.takeSecrets(jobID)
+	job.Status = "cancelled"
+	job.Error = errJobCancelled.Error()
+	job.EndTime = time.Now()
+	if err := p.server.JobStore.Save(job); err != nil {
+		return nil, err
+	}
+
+	p.server.Streams.Finish(jobID, job.Status)
+	p.server.recordJobFinished(job)
+	p.server.Logger.Info().Str("job_id", jobID).Msg("Queued job cancelled")
+	return job, nil
+}
+
 func (p *JobProcessor) updateJobStatus(job *Job, status, output, errMsg string) {
 	p.server.JobMutex.Lock()
-	defer p.server.JobMutex.Unlock()
-
 	job.Status = status
 	job.Output = output
 	if errMsg != "" {
 		job.Error = errMsg
 	}
 	job.EndTime = time.Now()
+	p.server.JobMutex.Unlock()
+
+	p.server.saveJob(job)
+	p.server.Streams.Finish(job.ID, status)
+	p.server.recordJobFinished(job)
+}
+
+var (
+	// reservedEnvironmentNames are variables a request may not set: they change
+	// which programs, libraries and Ansible configuration the server runs
+	reservedEnvironmentNames = map[string]bool{
+		"PATH": true, "HOME": true, "SHELL": true, "IFS": true, "ENV": true,
+		"BASH_ENV": true, "TMPDIR": true, "PERL5LIB": true, "PERL5OPT": true,
+		"RUBYOPT": true, "NODE_OPTIONS": true,
+	}
+	// reservedEnvironmentPrefixes cover Ansible settings, dynamic loader,
+	// Python, git, SSH and Kerberos variables
+	reservedEnvironmentPrefixes = []string{"ANSIBLE_", "LD_", "DYLD_", "PYTHON", "GIT_", "SSH_", "KRB5", "BASH_FUNC_"}
+)
+
+// reservedEnvironmentName reports whether a request may not set an environment variable
+func reservedEnvironmentName(name string) bool {
+	upper := strings.ToUpper(name)
+	if reservedEnvironmentNames[upper] {
+		return true
+	}
+	for _, prefix := range reservedEnvironmentPrefixes {
+		if strings.HasPrefix(upper, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestEnvironment returns request-provided environment variables as
+// NAME=value entries, sorted by name. Reserved names, which jobs stored
+// before they were rejected may still carry, are left out.
+func requestEnvironment(env map[string]string) []string {
+	entries := make([]string, 0, len(env))
+	for name, value := range env {
+		if !reservedEnvironmentName(name) {
+			entries = append(entries, name+"="+value)
+		}
+	}
+	sort.Strings(entries)
+	return entries
+}
+
+// playbookOptionArgs translates run options into ansible-playbook arguments
+func playbookOptionArgs(opts RunOptions) ([]string, error) {
+	var args []string
+
+	if len(opts.ExtraVars) > 0 {
+		extraVars, err := json.Marshal(opts.ExtraVars)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode extra vars: %w", err)
+		}
+		args = append(args, "--extra-vars", string(extraVars))
+	}
+	if len(opts.Tags) > 0 {
+		args = append(args, "--tags", strings.Join(opts.Tags, ","))
+	}
+	if len(opts.SkipTags) > 0 {
+		args = append(args, "--skip-tags", strings.Join(opts.SkipTags, ","))
+	}
+	if opts.CheckMode {
+		args = append(args, "--check")
+	}
+	if opts.Diff {
+		args = append(args, "--diff")
+	}
+	if opts.Verbosity > 0 {
+		args = append(args, "-"+strings.Repeat("v", opts.Verbosity))
+	}
+	if opts.Forks > 0 {
+		args = append(args, "--forks", strconv.Itoa(opts.Forks))
+	}
+	if opts.Become {
+		args = append(args, "--become")
+	}
+	if opts.StartAtTask != "" {
+		args = append(args, "--start-at-task", opts.StartAtTask)
+	}
+
+	return args, nil
 }
 
 func extractRepoPath(fullURL string) string {
@@ -433,182 +801,34 @@ func extractHost(repoURL string) string {
 	return u.Host
 }
 
-// gitOutputWriter is a custom writer to capture and format Git output
-type gitOutputWriter struct {
-	logger zerolog.Logger
-}
-
-func (w *gitOutputWriter) Write(p []byte) (n int, err error) {
-	// Convert Git's progress output to a single info line
-	output := strings.TrimSpace(string(p))
-	if output != "" {
-		w.logger.Info().Str("progress", output).Msg("Git clone progress")
-	}
-	return len(p), nil
-}
-
-func (p *JobProcessor) createStructuredOutput(rawOutput, rawError string, err error) string {
-	var result strings.Builder
-
-	// Add header
-	result.WriteString("=== ANSIBLE PLAYBOOK EXECUTION REPORT ===\n\n")
-
-	// Parse and structure the output
-	lines := strings.Split(rawOutput, "\n")
-
-	// Extract playbook name
-	playbookName := "Unknown"
-	for _, line := range lines {
-		if strings.Contains(line, "PLAY [") {
-			playbookName = strings.TrimSpace(strings.TrimPrefix(strings.TrimSuffix(line, "]"), "PLAY ["))
-			break
-		}
-	}
-
-	result.WriteString(fmt.Sprintf("📋 Playbook: %s\n", playbookName))
-	result.WriteString(fmt.Sprintf("⏱️  Execution Time: %s\n", time.Now().Format("2006-01-02 15:04:05")))
-	result.WriteString(fmt.Sprintf("🔧 Status: %s\n\n", map[bool]string{true: "❌ FAILED", false: "✅ SUCCESS"}[err != nil]))
-
-	// Extract and structure tasks
-	result.WriteString("📝 TASK EXECUTION SUMMARY:\n")
-	result.WriteString("─" + strings.Repeat("─", 50) + "\n")
-
-	taskResults := p.parseTaskResults(rawOutput)
-	for _, task := range taskResults {
-		status := "✅"
-		if task.Failed {
-			status = "❌"
-		} else if task.Changed {
-			status = "🔄"
-		} else if task.Skipped {
-			status = "⏭️"
-		}
-
-		result.WriteString(fmt.Sprintf("%s %s\n", status, task.Name))
-		if task.Host != "" {
-			result.WriteString(fmt.Sprintf("   Host: %s\n", task.Host))
-		}
-		if task.Error != "" {
-			result.WriteString(fmt.Sprintf("   Error: %s\n", task.Error))
-		}
-		result.WriteString("\n")
-	}
-
-	// Extract play recap
-	result.WriteString("📊 PLAY RECAP:\n")
-	result.WriteString("─" + strings.Repeat("─", 50) + "\n")
-
-	recap := p.parsePlayRecap(rawOutput)
-	for host, stats := range recap {
-		result.WriteString(fmt.Sprintf("🏠 %s:\n", host))
-		result.WriteString(fmt.Sprintf("   ✅ OK: %d\n", stats.Ok))
-		result.WriteString(fmt.Sprintf("   🔄 Changed: %d\n", stats.Changed))
-		result.WriteString(fmt.Sprintf("   ❌ Failed: %d\n", stats.Failed))
-		result.WriteString(fmt.Sprintf("   ⏭️ Skipped: %d\n", stats.Skipped))
-		result.WriteString(fmt.Sprintf("   🚫 Unreachable: %d\n", stats.Unreachable))
-		result.WriteString("\n")
-	}
-
-	// Add error details if any
-	if err != nil {
-		result.WriteString("🚨 ERROR DETAILS:\n")
-		result.WriteString("─" + strings.Repeat("─", 50) + "\n")
-		result.WriteString(fmt.Sprintf("Error: %s\n", err.Error()))
-
-		if rawError != "" {
-			result.WriteString(fmt.Sprintf("Stderr: %s\n", rawError))
-		}
-	}
-
-	// Add troubleshooting tips for common errors
-	if strings.Contains(rawOutput, "Connection refused") {
-		result.WriteString("\n💡 TROUBLESHOOTING TIP:\n")
-		result.WriteString("SSH connection refused. Check:\n")
-		result.WriteString("• SSH service is running on target host\n")
-		result.WriteString("• SSH keys are properly configured\n")
-		result.WriteString("• Firewall allows SSH connections\n")
-		result.WriteString("• Target host is reachable\n")
+// writeInventoryFile writes an inventory supplied with a request as an INI
+// inventory, one section per group
+func writeInventoryFile(path string, inventory map[string]map[string]string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
 	}
 
-	return result.String()
-}
-
-type TaskResult struct {
-	Name    string
-	Host    string
-	Failed  bool
-	Changed bool
-	Skipped bool
-	Error   string
-}
-
-type PlayRecap struct {
-	Ok          int
-	Changed     int
-	Failed      int
-	Skipped     int
-	Unreachable int
-}
-
-func (p *JobProcessor) parseTaskResults(output string) []TaskResult {
-	var tasks []TaskResult
-	lines := strings.Split(output, "\n")
-
-	for i, line := range lines {
-		if strings.Contains(line, "TASK [") {
-			taskName := strings.TrimSpace(strings.TrimPrefix(strings.TrimSuffix(line, "]"), "TASK ["))
-			task := TaskResult{Name: taskName}
-
-			// Look for task result in subsequent lines
-			for j := i + 1; j < len(lines) && j < i+10; j++ {
-				resultLine := lines[j]
-				if strings.Contains(resultLine, "fatal:") {
-					task.Failed = true
-					task.Error = strings.TrimSpace(strings.TrimPrefix(resultLine, "fatal:"))
-					break
-				} else if strings.Contains(resultLine, "changed:") {
-					task.Changed = true
-					break
-				} else if strings.Contains(resultLine, "skipping:") {
-					task.Skipped = true
-					break
-				} else if strings.Contains(resultLine, "ok:") {
-					break
-				}
-			}
-			tasks = append(tasks, task)
-		}
+	var buf bytes.Buffer
+	groups := make([]string, 0, len(inventory))
+	for group := range inventory {
+		groups = append(groups, group)
 	}
+	sort.Strings(groups)
 
-	return tasks
-}
-
-func (p *JobProcessor) parsePlayRecap(output string) map[string]PlayRecap {
-	recap := make(map[string]PlayRecap)
-	lines := strings.Split(output, "\n")
-
-	for _, line := range lines {
-		if strings.Contains(line, "PLAY RECAP") {
-			continue
+	for _, group := range groups {
+		fmt.Fprintf(&buf, "[%s]\n", group)
+		hosts := make([]string, 0, len(inventory[group]))
+		for host := range inventory[group] {
+			hosts = append(hosts, host)
 		}
-
-		// Parse lines like: localhost                  : ok=0    changed=0    unreachable=1    failed=0
-		if strings.Contains(line, ":") && (strings.Contains(line, "ok=") || strings.Contains(line, "failed=")) {
-			parts := strings.Split(line, ":")
-			if len(parts) == 2 {
-				host := strings.TrimSpace(parts[0])
-				stats := parts[1]
-
-				var playRecap PlayRecap
-				fmt.Sscanf(stats, "ok=%d changed=%d unreachable=%d failed=%d",
-					&playRecap.Ok, &playRecap.Changed, &playRecap.Unreachable, &playRecap.Failed)
-
-				recap[host] = playRecap
-			}
+		sort.Strings(hosts)
+		for _, host := range hosts {
+			fmt.Fprintf(&buf, "%s %s\n", host, inventory[group][host])
 		}
+		buf.WriteString("\n")
 	}
 
-	return recap
+	return os.WriteFile(path, buf.Bytes(), 0600)
 }
 
 // copyFile copies a file from src to dst
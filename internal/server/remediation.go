@@ -0,0 +1,293 @@
+package server
+
+import (
+	"ansible-api/internal/audit"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Remediation policies
+const (
+	// remediationAuto remediates detected drift immediately
+	remediationAuto = "auto"
+	// remediationManual records drift as a pending remediation for an operator to approve
+	remediationManual = "manual"
+	// remediationOff only reports drift
+	remediationOff = "off"
+)
+
+// Pending remediation statuses
+const (
+	remediationPending  = "pending"
+	remediationApproved = "approved"
+	remediationRejected = "rejected"
+)
+
+var (
+	errRemediationNotFound   = errors.New("remediation not found")
+	errRemediationNotPending = errors.New("remediation is not pending")
+)
+
+// remediationPolicy returns the policy that applies to a tracked playbook
+func (d *DriftDetector) remediationPolicy(state PlaybookState) string {
+	policy := state.RemediationPolicy
+	if policy == "" && d.server != nil && d.server.Config != nil {
+		policy = d.server.Config.DriftRemediationPolicy
+	}
+
+	switch policy {
+	case remediationAuto, remediationManual, remediationOff:
+		return policy
+	case "":
+		return remediationAuto
+	}
+
+	// An unrecognised policy never remediates on its own
+	d.logger.Warn().Str("policy", policy).Msg("Unknown remediation policy, treating as manual")
+	return remediationManual
+}
+
+// recordPendingRemediation updates the pending remediation for drift detected
+// at commit. A pending or approved remediation is kept, as is a rejection of
+// drift at the same commit; anything else opens a new pending remediation.
+func recordPendingRemediation(existing *PendingRemediation, commit string) *PendingRemediation {
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	if existing != nil {
+		keep := existing.Status == remediationPending ||
+			existing.Status == remediationApproved ||
+			(existing.Status == remediationRejected && existing.Commit == commit)
+		if keep {
+			updated := *existing
+			updated.LastDetectedAt = now
+			return &updated
+		}
+	}
+
+	return &PendingRemediation{
+		ID:             fmt.Sprintf("remediation-%d", time.Now().UnixNano()),
+		Status:         remediationPending,
+		Commit:         commit,
+		DetectedAt:     now,
+		LastDetectedAt: now,
+	}
+}
+
+// pendingRemediationStatus is the drift status reported while a remediation awaits or follows a decision
+func pendingRemediationStatus(pending *PendingRemediation) string {
+	if pending.Status == remediationPending {
+		return "pending_approval"
+	}
+	return pending.Status
+}
+
+// RemediationEntry returns the drift entry of a running automatic remediation
+func (d *DriftDetector) RemediationEntry(remediationID string) (DriftEntry, error) {
+	d.mu.Lock()
+	id, ok := d.remediations[remediationID]
+	d.mu.Unlock()
+	if !ok {
+		return DriftEntry{}, errRemediationNotFound
+	}
+	return d.Entry(id)
+}
+
+// findRemediation returns the drift entry that owns a remediation
+func (d *DriftDetector) findRemediation(id string) (string, PlaybookState, error) {
+	state, err := d.store.Load()
+	if err != nil {
+		return "", PlaybookState{}, err
+	}
+
+	for driftID, playbookState := range state {
+		if playbookState.PendingRemediation != nil && playbookState.PendingRemediation.ID == id {
+			return driftID, playbookState, nil
+		}
+	}
+	return "", PlaybookState{}, errRemediationNotFound
+}
+
+// DecideRemediation approves or rejects a pending remediation. Approval
+// returns the job that carries out the remediation; the caller queues it.
+func (d *DriftDetector) DecideRemediation(id string, approve bool, actor, reason string) (*PendingRemediation, *Job, error) {
+	var (
+		decided *PendingRemediation
+		job     *Job
+		driftID string
+	)
+
+	err := d.store.Update(func(state StateFile) error {
+		for entryID, playbookState := range state {
+			pending := playbookState.PendingRemediation
+			if pending == nil || pending.ID != id {
+				continue
+			}
+			if pending.Status != remediationPending {
+				decided = pending
+				return errRemediationNotPending
+			}
+
+			updated := *pending
+			updated.DecidedBy = actor
+			updated.DecidedAt = time.Now().UTC().Format(time.RFC3339)
+			updated.Reason = reason
+
+			if approve {
+				job = &Job{
+					ID:            fmt.Sprintf("job-%d", time.Now().UnixNano()),
+					Status:        "queued",
+					StartTime:     time.Now(),
+					RepositoryURL: playbookState.Repo,
+					PlaybookPath:  playbookState.Playbook,
+					Ref:           playbookState.Ref,
+					CommitSHA:     pending.Commit,
+					TargetHosts:   playbookState.TargetHosts,
+					RequestedBy:   actor,
+					Inventory:     playbookState.requestInventory(),
+					Environment:   playbookState.Environment,
+					RemediationOf: id,
+					DriftID:       entryID,
+					RunOptions:    playbookState.RunOptions,
+				}
+				updated.Status = remediationApproved
+				updated.JobID = job.ID
+			} else {
+				updated.Status = remediationRejected
+			}
+
+			playbookState.PendingRemediation = &updated
+			state[entryID] = playbookState
+			decided, driftID = &updated, entryID
+			return nil
+		}
+		return errRemediationNotFound
+	})
+	if err != nil {
+		return decided, nil, err
+	}
+
+	d.logger.Info().
+		Str("drift_id", driftID).
+		Str("remediation_id", id).
+		Str("status", decided.Status).
+		Str("decided_by", actor).
+		Msg("Remediation decided")
+	return decided, job, nil
+}
+
+// RemediationJobFinished closes the approved remediation a job carried out.
+// Jobs that ran the playbook are recorded by UpdatePlaybookState as well; this
+// also covers jobs that ended before that, such as a failed checkout, a
+// cancellation while queued or a restart, so the entry can open a new
+// pending remediation on its next check.
+func (d *DriftDetector) RemediationJobFinished(job *Job) error {
+	if job.RemediationOf == "" || job.DriftID == "" {
+		return nil
+	}
+
+	var closed bool
+	err := d.storePlaybookState(job.DriftID, func(current *PlaybookState) {
+		pending := current.PendingRemediation
+		if pending == nil || pending.ID != job.RemediationOf || pending.Status != remediationApproved {
+			return
+		}
+		current.PendingRemediation = nil
+		current.LastRemediation = job.EndTime.UTC().Format(time.RFC3339)
+		current.LastRemediationStatus = job.Status
+		current.LastRemediationID = job.ID
+		closed = true
+	})
+	if err != nil {
+		return err
+	}
+
+	if closed {
+		d.logger.Info().
+			Str("drift_id", job.DriftID).
+			Str("remediation_id", job.RemediationOf).
+			Str("job_id", job.ID).
+			Str("status", job.Status).
+			Msg("Approved remediation finished")
+	}
+	return nil
+}
+
+// decideRemediation handles POST /api/drift/<remediation_id>/approve and /reject
+func (s *Server) decideRemediation(c *gin.Context, id string, approve bool) {
+	reqLogger := s.Logger.With().
+		Str("remediation_id", id).
+		Str("endpoint", c.Request.URL.Path).
+		Str("remote_addr", c.ClientIP()).
+		Logger()
+
+	var req RemediationDecisionRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			reqLogger.Error().Err(err).Msg("Invalid request body")
+			c.JSON(400, gin.H{"error": "Invalid request body"})
+			return
+		}
+		if err := NewRequestValidator().ValidateRemediationDecisionRequest(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	driftID, playbookState, err := s.Drift.findRemediation(id)
+	switch {
+	case errors.Is(err, errRemediationNotFound):
+		c.JSON(404, gin.H{"error": "Remediation not found"})
+		return
+	case err != nil:
+		reqLogger.Error().Err(err).Msg("Failed to load drift state")
+		c.JSON(500, gin.H{"error": "Failed to load drift state"})
+		return
+	}
+
+	if !s.authorizeTarget(c, playbookState.Repo, playbookState.Playbook) {
+		return
+	}
+
+	pending, job, err := s.Drift.DecideRemediation(id, approve, principal(c).Name, req.Reason)
+	switch {
+	case errors.Is(err, errRemediationNotFound):
+		c.JSON(404, gin.H{"error": "Remediation not found"})
+		return
+	case errors.Is(err, errRemediationNotPending):
+		c.JSON(409, gin.H{"error": err.Error(), "status": pending.Status})
+		return
+	case err != nil:
+		reqLogger.Error().Err(err).Msg("Failed to record remediation decision")
+		c.JSON(500, gin.H{"error": "Failed to record remediation decision"})
+		return
+	}
+
+	event := audit.Event{
+		Action:        "drift.remediation.reject",
+		RepositoryURL: playbookState.Repo,
+		PlaybookPath:  playbookState.Playbook,
+		Ref:           playbookState.Ref,
+		CommitSHA:     pending.Commit,
+		TargetHosts:   playbookState.TargetHosts,
+		Status:        pending.Status,
+		Detail:        id,
+	}
+
+	if job == nil {
+		s.recordAudit(c, event)
+		reqLogger.Info().Msg("Remediation rejected")
+		c.JSON(200, gin.H{"status": pending.Status, "remediation_id": id, "drift_id": driftID})
+		return
+	}
+
+	s.queueJob(job)
+	event.Action = "drift.remediation.approve"
+	event.JobID = job.ID
+	s.recordAudit(c, event)
+
+	reqLogger.Info().Str("job_id", job.ID).Msg("Remediation approved and queued")
+	c.JSON(202, gin.H{"status": pending.Status, "remediation_id": id, "drift_id": driftID, "job_id": job.ID})
+}
@@ -0,0 +1,485 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	repoMirrorSuffix   = ".git"
+	repoMirrorMetaFile = "ansible-api.json"
+	// repoRemoteHead is where the remote's default branch is recorded in a mirror
+	repoRemoteHead = "refs/remotes/origin/HEAD"
+)
+
+var (
+	abbreviatedSHARegex = regexp.MustCompile(`^[0-9a-fA-F]{7,40}$`)
+	fullCommitSHARegex  = regexp.MustCompile(`^[0-9a-fA-F]{40}$`)
+)
+
+// repoMirrorRefspecs mirror every branch and tag and record the remote HEAD
+var repoMirrorRefspecs = []string{
+	"+refs/heads/*:refs/heads/*",
+	"+refs/tags/*:refs/tags/*",
+	"+HEAD:" + repoRemoteHead,
+}
+
+// NewRepoCache opens (or creates) the mirror cache in dir. A maxBytes of zero
+// or less disables the cache and every checkout becomes a shallow fetch.
+func NewRepoCache(dir string, maxBytes int64) (*RepoCache, error) {
+	cache := &RepoCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		mirrors:  make(map[string]*repoMirror),
+		logger:   log.With().Str("component", "repo-cache").Logger(),
+	}
+
+	if !cache.Enabled() {
+		cache.logger.Info().Msg("Repository cache disabled, using shallow checkouts")
+		return cache, nil
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create repository cache directory %s: %w", dir, err)
+	}
+
+	if err := cache.load(); err != nil {
+		return nil, err
+	}
+
+	return cache, nil
+}
+
+// Enabled reports whether mirrors are kept between runs
+func (c *RepoCache) Enabled() bool {
+	return c.maxBytes > 0
+}
+
+// load registers the mirrors already on disk and prunes worktrees left behind
+// by runs that did not finish
+func (c *RepoCache) load() error {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read repository cache directory %s: %w", c.dir, err)
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasSuffix(entry.Name(), repoMirrorSuffix) {
+			continue
+		}
+
+		path := filepath.Join(c.dir, entry.Name())
+		data, err := os.ReadFile(filepath.Join(path, repoMirrorMetaFile))
+		if err != nil {
+			c.logger.Warn().Err(err).Str("path", path).Msg("Mirror has no metadata, removing")
+			os.RemoveAll(path)
+			continue
+		}
+
+		var meta repoMirrorMeta
+		if err := json.Unmarshal(data, &meta); err != nil || meta.RepositoryURL == "" {
+			c.logger.Warn().Err(err).Str("path", path).Msg("Failed to decode mirror metadata, removing")
+			os.RemoveAll(path)
+			continue
+		}
+
+		if _, err := runGit(context.Background(), path, "worktree", "prune"); err != nil {
+			c.logger.Warn().Err(err).Str("path", path).Msg("Failed to prune stale worktrees")
+		}
+
+		key := strings.TrimSuffix(entry.Name(), repoMirrorSuffix)
+		c.mirrors[key] = &repoMirror{
+			key:         key,
+			path:        path,
+			url:         meta.RepositoryURL,
+			sizeBytes:   dirSize(path),
+			lastUsed:    meta.LastUsed,
+			lastFetched: meta.LastFetched,
+		}
+	}
+
+	c.logger.Info().Str("dir", c.dir).Int("mirrors", len(c.mirrors)).Msg("Repository cache loaded")
+	return nil
+}
+
+// Checkout places repoURL at revision (a branch, tag or commit SHA; the
+// default branch when empty) in dest, which must be missing or empty, and
+// returns the commit checked out. fetchURL is the authenticated URL used to
+// talk to the remote. The returned release function must be called once dest
+// is no longer needed.
+func (c *RepoCache) Checkout(ctx context.Context, repoURL, fetchURL, revision, dest string) (string, func(), error) {
+	if !c.Enabled() {
+		commit, err := shallowCheckout(ctx, fetchURL, revision, dest)
+		return commit, func() {}, err
+	}
+
+	dest, err := filepath.Abs(dest)
+	if err != nil {
+		return "", nil, err
+	}
+
+	mirror := c.acquire(repoURL)
+	release := func() {
+		mirror.mu.Lock()
+		if _, err := runGit(context.Background(), mirror.path, "worktree", "remove", "--force", dest); err != nil {
+			c.logger.Warn().Err(err).Str("worktree", dest).Msg("Failed to remove worktree")
+			runGit(context.Background(), mirror.path, "worktree", "prune")
+		}
+		mirror.mu.Unlock()
+		c.release(mirror)
+	}
+
+	commit, err := c.checkoutLocked(ctx, mirror, fetchURL, revision, dest)
+	if err != nil {
+		c.release(mirror)
+		c.discardUnfetched(mirror)
+		return "", nil, err
+	}
+
+	return commit, release, nil
+}
+
+// checkoutLocked updates the mirror if needed and adds a detached worktree
+func (c *RepoCache) checkoutLocked(ctx context.Context, mirror *repoMirror, fetchURL, revision, dest string) (string, error) {
+	mirror.mu.Lock()
+	defer mirror.mu.Unlock()
+
+	mirrorLogger := c.logger.With().Str("repository", mirror.url).Str("ref", revision).Logger()
+
+	if _, err := os.Stat(filepath.Join(mirror.path, "HEAD")); os.IsNotExist(err) {
+		mirrorLogger.Info().Str("path", mirror.path).Msg("Creating repository mirror")
+		if _, err := runGit(ctx, c.dir, "init", "--bare", "--quiet", mirror.path); err != nil {
+			return "", err
+		}
+	}
+
+	// A full commit SHA never moves, so a mirror that already has it is used as is
+	commit := ""
+	if fullCommitSHARegex.MatchString(revision) {
+		commit, _ = resolveCommit(ctx, mirror.path, revision)
+	}
+
+	if commit != "" {
+		c.mu.Lock()
+		mirror.hits++
+		c.mu.Unlock()
+		mirrorLogger.Debug().Str("commit", commit).Msg("Pinned commit found in mirror, skipping fetch")
+	} else {
+		mirrorLogger.Info().Msg("Fetching repository into mirror")
+		args := append([]string{"fetch", "--prune", "--quiet", fetchURL}, repoMirrorRefspecs...)
+		if _, err := runGitMasked(ctx, mirror.path, fetchURL, args...); err != nil {
+			return "", err
+		}
+		size := dirSize(mirror.path)
+		c.mu.Lock()
+		mirror.fetches++
+		mirror.lastFetched = time.Now()
+		mirror.sizeBytes = size
+		c.mu.Unlock()
+
+		commit, _ = resolveCommit(ctx, mirror.path, mirrorRevision(revision))
+
+		// Commits no longer reachable from a branch or tag can still be fetched directly
+		if commit == "" && fullCommitSHARegex.MatchString(revision) {
+			if _, err := runGitMasked(ctx, mirror.path, fetchURL, "fetch", "--quiet", fetchURL, revision); err == nil {
+				commit, _ = resolveCommit(ctx, mirror.path, revision)
+			}
+		}
+	}
+
+	c.saveMeta(mirror)
+
+	if commit == "" {
+		return "", fmt.Errorf("ref %q not found in repository", revision)
+	}
+
+	if _, err := runGit(ctx, mirror.path, "worktree", "add", "--detach", "--quiet", dest, commit); err != nil {
+		return "", err
+	}
+
+	return commit, nil
+}
+
+// acquire returns the mirror for a repository URL, marking it in use
+func (c *RepoCache) acquire(repoURL string) *repoMirror {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := repoCacheKey(repoURL)
+	mirror, ok := c.mirrors[key]
+	if !ok {
+		mirror = &repoMirror{
+			key:  key,
+			path: filepath.Join(c.dir, key+repoMirrorSuffix),
+			url:  repoURL,
+		}
+		c.mirrors[key] = mirror
+	}
+	mirror.inUse++
+	mirror.lastUsed = time.Now()
+	return mirror
+}
+
+// release marks a mirror idle again and enforces the size limit, since
+// mirrors in use cannot be evicted
+func (c *RepoCache) release(mirror *repoMirror) {
+	c.mu.Lock()
+	mirror.inUse--
+	c.mu.Unlock()
+
+	c.evict()
+}
+
+// discardUnfetched drops a mirror whose first fetch failed so it is neither
+// listed nor left half-initialised on disk
+func (c *RepoCache) discardUnfetched(mirror *repoMirror) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if mirror.inUse > 0 || !mirror.lastFetched.IsZero() || c.mirrors[mirror.key] != mirror {
+		return
+	}
+	os.RemoveAll(mirror.path)
+	delete(c.mirrors, mirror.key)
+}
+
+// evict removes the least recently used idle mirrors until the cache fits its limit
+func (c *RepoCache) evict() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var total int64
+	mirrors := make([]*repoMirror, 0, len(c.mirrors))
+	for _, mirror := range c.mirrors {
+		total += mirror.sizeBytes
+		mirrors = append(mirrors, mirror)
+	}
+	if total <= c.maxBytes {
+		return
+	}
+
+	sort.Slice(mirrors, func(i, j int) bool {
+		return mirrors[i].lastUsed.Before(mirrors[j].lastUsed)
+	})
+
+	for _, mirror := range mirrors {
+		if total <= c.maxBytes {
+			break
+		}
+		if mirror.inUse > 0 {
+			continue
+		}
+		if err := os.RemoveAll(mirror.path); err != nil {
+			c.logger.Error().Err(err).Str("repository", mirror.url).Msg("Failed to evict mirror")
+			continue
+		}
+		delete(c.mirrors, mirror.key)
+		total -= mirror.sizeBytes
+
+		c.logger.Info().
+			Str("repository", mirror.url).
+			Int64("size_bytes", mirror.sizeBytes).
+			Time("last_used", mirror.lastUsed).
+			Msg("Evicted repository mirror")
+	}
+
+	if total > c.maxBytes {
+		c.logger.Warn().Int64("total_bytes", total).Int64("max_bytes", c.maxBytes).Msg("Repository cache over limit, all remaining mirrors are in use")
+	}
+}
+
+// saveMeta persists a mirror's metadata; the caller holds the mirror lock
+func (c *RepoCache) saveMeta(mirror *repoMirror) {
+	c.mu.Lock()
+	meta := repoMirrorMeta{
+		RepositoryURL: mirror.url,
+		LastUsed:      mirror.lastUsed,
+		LastFetched:   mirror.lastFetched,
+	}
+	c.mu.Unlock()
+
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(filepath.Join(mirror.path, repoMirrorMetaFile), data, 0600)
+	}
+	if err != nil {
+		c.logger.Warn().Err(err).Str("repository", mirror.url).Msg("Failed to save mirror metadata")
+	}
+}
+
+// Status returns the cache contents, most recently used first
+func (c *RepoCache) Status() RepoCacheStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	status := RepoCacheStatus{
+		Enabled:      c.Enabled(),
+		Dir:          c.dir,
+		MaxBytes:     c.maxBytes,
+		Repositories: []RepoCacheEntry{},
+	}
+
+	for _, mirror := range c.mirrors {
+		status.TotalBytes += mirror.sizeBytes
+		status.Repositories = append(status.Repositories, RepoCacheEntry{
+			RepositoryURL: mirror.url,
+			Path:          mirror.path,
+			SizeBytes:     mirror.sizeBytes,
+			LastUsed:      mirror.lastUsed,
+			LastFetched:   mirror.lastFetched,
+			InUse:         mirror.inUse,
+			Hits:          mirror.hits,
+			Fetches:       mirror.fetches,
+		})
+	}
+
+	sort.Slice(status.Repositories, func(i, j int) bool {
+		return status.Repositories[i].LastUsed.After(status.Repositories[j].LastUsed)
+	})
+
+	return status
+}
+
+// shallowCheckout fetches only the requested revision into dest. Abbreviated
+// commit SHAs cannot be fetched directly, so they fall back to a full fetch.
+func shallowCheckout(ctx context.Context, fetchURL, revision, dest string) (string, error) {
+	if _, err := runGit(ctx, "", "init", "--quiet", dest); err != nil {
+		return "", err
+	}
+
+	target := revision
+	if target == "" {
+		target = "HEAD"
+	}
+
+	_, err := runGitMasked(ctx, dest, fetchURL, "fetch", "--depth", "1", "--quiet", fetchURL, target)
+	if err == nil {
+		commit, err := resolveCommit(ctx, dest, "FETCH_HEAD")
+		if err != nil {
+			return "", err
+		}
+		if _, err := runGit(ctx, dest, "checkout", "--quiet", "--detach", commit); err != nil {
+			return "", err
+		}
+		return commit, nil
+	}
+	if !abbreviatedSHARegex.MatchString(revision) {
+		return "", err
+	}
+
+	args := []string{"fetch", "--quiet", fetchURL, "+refs/heads/*:refs/remotes/origin/*", "+refs/tags/*:refs/tags/*"}
+	if _, err := runGitMasked(ctx, dest, fetchURL, args...); err != nil {
+		return "", err
+	}
+	commit, err := resolveCommit(ctx, dest, revision)
+	if err != nil {
+		return "", fmt.Errorf("ref %q not found in repository", revision)
+	}
+	if _, err := runGit(ctx, dest, "checkout", "--quiet", "--detach", commit); err != nil {
+		return "", err
+	}
+	return commit, nil
+}
+
+// mirrorRevision maps a requested revision onto the refs kept in a mirror
+func mirrorRevision(revision string) string {
+	if revision == "" {
+		return repoRemoteHead
+	}
+	return revision
+}
+
+// resolveCommit resolves a revision to a full commit SHA within a repository
+func resolveCommit(ctx context.Context, repoDir, revision string) (string, error) {
+	out, err := runGit(ctx, repoDir, "rev-parse", "--verify", "--quiet", revision+"^{commit}")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// runGit runs git in dir and returns its stdout
+func runGit(ctx context.Context, dir string, args ...string) (string, error) {
+	return runGitMasked(ctx, dir, "", args...)
+}
+
+// runGitMasked runs git like runGit, masking the credentials in secretURL
+// wherever it appears in the returned error
+func runGitMasked(ctx context.Context, dir, secretURL string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := runProcess(ctx, cmd); err != nil {
+		command := strings.Join(args, " ")
+		detail := strings.TrimSpace(stderr.String())
+		if secretURL != "" {
+			command = strings.ReplaceAll(command, secretURL, maskTokenInURL(secretURL))
+			detail = strings.ReplaceAll(detail, secretURL, maskTokenInURL(secretURL))
+		}
+		if detail != "" {
+			return "", fmt.Errorf("git %s failed: %w: %s", command, err, detail)
+		}
+		return "", fmt.Errorf("git %s failed: %w", command, err)
+	}
+
+	return stdout.String(), nil
+}
+
+// repoCacheKey derives a stable directory name from a repository URL
+func repoCacheKey(repoURL string) string {
+	sum := sha256.Sum256([]byte(normalizeRepoURL(repoURL)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// normalizeRepoURL maps the spellings of a repository URL (case, trailing
+// slash, .git suffix) to one form
+func normalizeRepoURL(repoURL string) string {
+	normalized := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(repoURL)), "/")
+	return strings.TrimSuffix(normalized, ".git")
+}
+
+// dirSize returns the total size of the files below path
+func dirSize(path string) int64 {
+	var size int64
+	filepath.WalkDir(path, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !entry.IsDir() {
+			if info, err := entry.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+func (s *Server) handleRepoCache(c *gin.Context) {
+	s.Logger.Debug().
+		Str("endpoint", "/api/repos/cache").
+		Str("remote_addr", c.ClientIP()).
+		Msg("Repository cache status requested")
+
+	c.JSON(200, s.RepoCache.Status())
+}
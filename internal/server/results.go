@@ -0,0 +1,268 @@
+package server
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const resultCallbackName = "ansible_api_json"
+
+//go:embed callback_plugins/ansible_api_json.py
+var resultCallbackPlugin []byte
+
+// prepareResultCallback installs the structured result callback plugin into
+// dir and returns the environment needed to enable it alongside the default
+// stdout callback, plus the path the results will be written to.
+func prepareResultCallback(dir string) ([]string, string, error) {
+	pluginDir := filepath.Join(dir, "callback_plugins")
+	if err := os.MkdirAll(pluginDir, 0700); err != nil {
+		return nil, "", fmt.Errorf("failed to create callback plugin directory: %w", err)
+	}
+
+	pluginPath := filepath.Join(pluginDir, resultCallbackName+".py")
+	if err := os.WriteFile(pluginPath, resultCallbackPlugin, 0600); err != nil {
+		return nil, "", fmt.Errorf("failed to write callback plugin: %w", err)
+	}
+
+	resultFile := filepath.Join(dir, "result.json")
+
+	// Keep any callbacks the operator has already enabled through the environment
+	pluginPaths := pluginDir
+	if existing := os.Getenv("ANSIBLE_CALLBACK_PLUGINS"); existing != "" {
+		pluginPaths += ":" + existing
+	}
+	enabled := resultCallbackName
+	if existing := os.Getenv("ANSIBLE_CALLBACKS_ENABLED"); existing != "" {
+		enabled += "," + existing
+	}
+
+	env := []string{
+		"ANSIBLE_CALLBACK_PLUGINS=" + pluginPaths,
+		"ANSIBLE_CALLBACKS_ENABLED=" + enabled,
+		"ANSIBLE_CALLBACK_WHITELIST=" + enabled, // ansible < 2.11
+		"ANSIBLE_API_RESULT_FILE=" + resultFile,
+	}
+
+	return env, resultFile, nil
+}
+
+// loadPlaybookResult reads the callback output and derives durations and
+// totals. redact is applied to the raw document before it is decoded.
+func loadPlaybookResult(path string, redact func(string) string) (*PlaybookResult, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data := []byte(redact(string(content)))
+
+	var raw struct {
+		Start time.Time `json:"start"`
+		End   time.Time `json:"end"`
+		Plays []struct {
+			Play struct {
+				Name  string    `json:"name"`
+				ID    string    `json:"id"`
+				Start time.Time `json:"start"`
+				End   time.Time `json:"end"`
+			} `json:"play"`
+			Tasks []struct {
+				Task struct {
+					Name    string    `json:"name"`
+					ID      string    `json:"id"`
+					Action  string    `json:"action"`
+					Handler bool      `json:"handler"`
+					Start   time.Time `json:"start"`
+					End     time.Time `json:"end"`
+				} `json:"task"`
+				Hosts map[string]HostTaskResult `json:"hosts"`
+			} `json:"tasks"`
+		} `json:"plays"`
+		Stats map[string]HostStats `json:"stats"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to decode playbook result: %w", err)
+	}
+
+	result := &PlaybookResult{
+		Start:           raw.Start,
+		End:             raw.End,
+		DurationSeconds: durationSeconds(raw.Start, raw.End),
+		Stats:           raw.Stats,
+	}
+	if result.Stats == nil {
+		result.Stats = make(map[string]HostStats)
+	}
+
+	for _, p := range raw.Plays {
+		play := PlayResult{
+			Name:            p.Play.Name,
+			ID:              p.Play.ID,
+			Start:           p.Play.Start,
+			End:             p.Play.End,
+			DurationSeconds: durationSeconds(p.Play.Start, p.Play.End),
+		}
+		for _, t := range p.Tasks {
+			play.Tasks = append(play.Tasks, TaskResult{
+				Name:            t.Task.Name,
+				ID:              t.Task.ID,
+				Action:          t.Task.Action,
+				Handler:         t.Task.Handler,
+				Start:           t.Task.Start,
+				End:             t.Task.End,
+				DurationSeconds: durationSeconds(t.Task.Start, t.Task.End),
+				Hosts:           t.Hosts,
+			})
+		}
+		result.Plays = append(result.Plays, play)
+	}
+
+	for _, stats := range result.Stats {
+		result.Totals.Ok += stats.Ok
+		result.Totals.Changed += stats.Changed
+		result.Totals.Unreachable += stats.Unreachable
+		result.Totals.Failures += stats.Failures
+		result.Totals.Skipped += stats.Skipped
+		result.Totals.Rescued += stats.Rescued
+		result.Totals.Ignored += stats.Ignored
+	}
+
+	return result, nil
+}
+
+func durationSeconds(start, end time.Time) float64 {
+	if start.IsZero() || end.IsZero() {
+		return 0
+	}
+	return end.Sub(start).Seconds()
+}
+
+// PlaybookName returns the name of the first play, which is how runs are labelled in reports
+func (r *PlaybookResult) PlaybookName() string {
+	for _, play := range r.Plays {
+		if play.Name != "" {
+			return play.Name
+		}
+	}
+	return "Unknown"
+}
+
+// renderTextReport renders the human-readable job report from a structured
+// result. When the callback produced no result (for example a syntax error
+// before the first play) the raw output is included instead.
+func renderTextReport(result *PlaybookResult, rawOutput, rawError string, err error) string {
+	var report strings.Builder
+	separator := "─" + strings.Repeat("─", 50) + "\n"
+
+	report.WriteString("=== ANSIBLE PLAYBOOK EXECUTION REPORT ===\n\n")
+
+	playbookName := "Unknown"
+	if result != nil {
+		playbookName = result.PlaybookName()
+	}
+
+	report.WriteString(fmt.Sprintf("📋 Playbook: %s\n", playbookName))
+	report.WriteString(fmt.Sprintf("⏱️  Execution Time: %s\n", time.Now().Format("2006-01-02 15:04:05")))
+	if result != nil && result.DurationSeconds > 0 {
+		report.WriteString(fmt.Sprintf("⌛ Duration: %.1fs\n", result.DurationSeconds))
+	}
+	report.WriteString(fmt.Sprintf("🔧 Status: %s\n\n", map[bool]string{true: "❌ FAILED", false: "✅ SUCCESS"}[err != nil]))
+
+	if result == nil {
+		report.WriteString("⚠️ Structured results unavailable, raw output follows:\n")
+		report.WriteString(separator)
+		report.WriteString(rawOutput)
+		report.WriteString("\n")
+	} else {
+		report.WriteString("📝 TASK EXECUTION SUMMARY:\n")
+		report.WriteString(separator)
+
+		for _, play := range result.Plays {
+			for _, task := range play.Tasks {
+				for _, host := range sortedHosts(task.Hosts) {
+					hostResult := task.Hosts[host]
+					report.WriteString(fmt.Sprintf("%s %s\n", taskStatusIcon(hostResult.Status), task.Name))
+					report.WriteString(fmt.Sprintf("   Host: %s\n", host))
+					if hostResult.Status == "failed" || hostResult.Status == "unreachable" {
+						if hostResult.Msg != "" {
+							report.WriteString(fmt.Sprintf("   Error: %s\n", hostResult.Msg))
+						}
+					}
+					report.WriteString("\n")
+				}
+			}
+		}
+
+		report.WriteString("📊 PLAY RECAP:\n")
+		report.WriteString(separator)
+
+		hosts := make([]string, 0, len(result.Stats))
+		for host := range result.Stats {
+			hosts = append(hosts, host)
+		}
+		sort.Strings(hosts)
+
+		for _, host := range hosts {
+			stats := result.Stats[host]
+			report.WriteString(fmt.Sprintf("🏠 %s:\n", host))
+			report.WriteString(fmt.Sprintf("   ✅ OK: %d\n", stats.Ok))
+			report.WriteString(fmt.Sprintf("   🔄 Changed: %d\n", stats.Changed))
+			report.WriteString(fmt.Sprintf("   ❌ Failed: %d\n", stats.Failures))
+			report.WriteString(fmt.Sprintf("   ⏭️ Skipped: %d\n", stats.Skipped))
+			report.WriteString(fmt.Sprintf("   🚫 Unreachable: %d\n", stats.Unreachable))
+			report.WriteString(fmt.Sprintf("   🛟 Rescued: %d\n", stats.Rescued))
+			report.WriteString(fmt.Sprintf("   🙈 Ignored: %d\n", stats.Ignored))
+			report.WriteString("\n")
+		}
+	}
+
+	if err != nil {
+		report.WriteString("🚨 ERROR DETAILS:\n")
+		report.WriteString(separator)
+		report.WriteString(fmt.Sprintf("Error: %s\n", err.Error()))
+
+		if rawError != "" {
+			report.WriteString(fmt.Sprintf("Stderr: %s\n", rawError))
+		}
+	}
+
+	// Add troubleshooting tips for common errors
+	if strings.Contains(rawOutput, "Connection refused") {
+		report.WriteString("\n💡 TROUBLESHOOTING TIP:\n")
+		report.WriteString("SSH connection refused. Check:\n")
+		report.WriteString("• SSH service is running on target host\n")
+		report.WriteString("• SSH keys are properly configured\n")
+		report.WriteString("• Firewall allows SSH connections\n")
+		report.WriteString("• Target host is reachable\n")
+	}
+
+	return report.String()
+}
+
+func taskStatusIcon(status string) string {
+	switch status {
+	case "failed", "unreachable":
+		return "❌"
+	case "changed":
+		return "🔄"
+	case "skipped":
+		return "⏭️"
+	case "ignored":
+		return "🙈"
+	}
+	return "✅"
+}
+
+func sortedHosts(hosts map[string]HostTaskResult) []string {
+	names := make([]string, 0, len(hosts))
+	for host := range hosts {
+		names = append(names, host)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"ansible-api/internal/audit"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+const jobJanitorInterval = 15 * time.Minute
+
+// NewJobJanitor creates a janitor that prunes jobs older than the configured retention
+func NewJobJanitor(server *Server) *JobJanitor {
+	return &JobJanitor{
+		server:   server,
+		interval: jobJanitorInterval,
+		logger:   log.With().Str("component", "job-janitor").Logger(),
+	}
+}
+
+// Start runs the janitor periodically in the background
+func (j *JobJanitor) Start() {
+	go func() {
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			j.Cleanup(false)
+		}
+	}()
+}
+
+// Cleanup removes finished jobs older than the retention window. Jobs are
+// grouped into retry chains and a chain is only pruned once every job in it
+// has finished and the most recent one ended before the cutoff, so lineage is
+// never broken and in-flight retries keep their history.
+func (j *JobJanitor) Cleanup(dryRun bool) CleanupReport {
+	retention := time.Duration(j.server.Config.RetentionHours) * time.Hour
+	report := CleanupReport{
+		Cutoff:  time.Now().Add(-retention),
+		DryRun:  dryRun,
+		Removed: []string{},
+	}
+
+	if j.server.Config.RetentionHours <= 0 {
+		j.logger.Debug().Msg("Job retention disabled, skipping cleanup")
+		return report
+	}
+
+	jobs := j.server.JobStore.List()
+	byID := make(map[string]*Job, len(jobs))
+	for _, job := range jobs {
+		byID[job.ID] = job
+	}
+
+	chains := make(map[string][]*Job)
+	for _, job := range jobs {
+		root := retryChainRoot(job, byID)
+		chains[root] = append(chains[root], job)
+	}
+
+	for root, chain := range chains {
+		prunable := true
+		for _, job := range chain {
+			if !isTerminalStatus(job.Status) || job.EndTime.IsZero() || job.EndTime.After(report.Cutoff) {
+				prunable = false
+				break
+			}
+		}
+
+		if !prunable {
+			report.Retained += len(chain)
+			continue
+		}
+
+		for _, job := range chain {
+			if !dryRun {
+				if err := j.server.JobStore.Delete(job.ID); err != nil {
+					j.logger.Error().Err(err).Str("job_id", job.ID).Msg("Failed to delete expired job")
+					report.Retained++
+					continue
+				}
+			}
+			report.Removed = append(report.Removed, job.ID)
+		}
+
+		j.logger.Debug().
+			Str("chain_root", root).
+			Int("jobs", len(chain)).
+			Bool("dry_run", dryRun).
+			Msg("Pruned expired retry chain")
+	}
+
+	j.logger.Info().
+		Time("cutoff", report.Cutoff).
+		Int("removed", len(report.Removed)).
+		Int("retained", report.Retained).
+		Bool("dry_run", dryRun).
+		Msg("Job cleanup completed")
+
+	return report
+}
+
+// retryChainRoot follows RetryOf links back to the first job in a retry chain
+func retryChainRoot(job *Job, byID map[string]*Job) string {
+	current := job
+	seen := map[string]bool{current.ID: true}
+	for current.RetryOf != "" {
+		parent, exists := byID[current.RetryOf]
+		if !exists || seen[parent.ID] {
+			return current.RetryOf
+		}
+		seen[parent.ID] = true
+		current = parent
+	}
+	return current.ID
+}
+
+// isTerminalStatus reports whether a job status is final
+func isTerminalStatus(status string) bool {
+	switch status {
+	case "completed", "failed", "interrupted", "cancelled", "timed_out":
+		return true
+	}
+	return false
+}
+
+func (s *Server) handleJobCleanup(c *gin.Context) {
+	dryRun := c.Query("dry_run") == "true"
+
+	s.Logger.Info().
+		Str("endpoint", "/api/admin/jobs/cleanup").
+		Str("remote_addr", c.ClientIP()).
+		Bool("dry_run", dryRun).
+		Msg("Job cleanup requested")
+
+	report := s.JobJanitor.Cleanup(dryRun)
+	if !dryRun {
+		s.recordAudit(c, audit.Event{Action: "jobs.cleanup", Detail: fmt.Sprintf("removed %d jobs", len(report.Removed))})
+	}
+
+	c.JSON(200, report)
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const redactedValue = "********"
+
+// errSecretsUnavailable fails a job whose secrets were lost, e.g. by a service restart
+var errSecretsUnavailable = errors.New("job secrets are no longer available, retry the job with its secrets")
+
+// SetSecrets holds a job's secrets in memory until a worker picks the job up.
+// Secrets are never stored on the Job, so they are not persisted or returned by the API.
+func (p *JobProcessor) SetSecrets(jobID string, secrets map[string]string) {
+	if len(secrets) == 0 {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.secrets[jobID] = secrets
+}
+
+// takeSecrets removes and returns the secrets held for a job
+func (p *JobProcessor) takeSecrets(jobID string) map[string]string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	secrets := p.secrets[jobID]
+	delete(p.secrets, jobID)
+	return secrets
+}
+
+// writeSecretsFile writes secrets to a private temporary extra-vars file.
+// The caller must remove the file once the playbook has finished.
+func writeSecretsFile(secrets map[string]string) (string, error) {
+	data, err := json.Marshal(secrets)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode secrets: %w", err)
+	}
+
+	tmpFile, err := os.CreateTemp("", "ansible-api-secrets-*.json")
+	if err != nil {
+		return "", fmt.Errorf("failed to create secrets file: %w", err)
+	}
+
+	if err := tmpFile.Chmod(0600); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+		return "", fmt.Errorf("failed to set permissions on secrets file: %w", err)
+	}
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+		return "", fmt.Errorf("failed to write secrets file: %w", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		os.Remove(tmpFile.Name())
+		return "", fmt.Errorf("failed to close secrets file: %w", err)
+	}
+
+	return tmpFile.Name(), nil
+}
+
+// newRedactor returns a function masking every secret value in a string.
+// Longer values are replaced first so overlapping secrets are fully masked,
+// and the JSON-escaped form of each value is masked as well.
+func newRedactor(secrets map[string]string) func(string) string {
+	var values []string
+	seen := make(map[string]bool)
+	for _, value := range secrets {
+		if value == "" {
+			continue
+		}
+		variants := []string{value}
+		if encoded, err := json.Marshal(value); err == nil {
+			variants = append(variants, strings.Trim(string(encoded), `"`))
+		}
+		for _, variant := range variants {
+			if !seen[variant] {
+				seen[variant] = true
+				values = append(values, variant)
+			}
+		}
+	}
+
+	if len(values) == 0 {
+		return func(s string) string { return s }
+	}
+
+	sort.Slice(values, func(i, j int) bool {
+		return len(values[i]) > len(values[j])
+	})
+
+	pairs := make([]string, 0, len(values)*2)
+	for _, value := range values {
+		pairs = append(pairs, value, redactedValue)
+	}
+	replacer := strings.NewReplacer(pairs...)
+
+	return replacer.Replace
+}
@@ -0,0 +1,276 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// streamRetention is how long a finished job's stream stays available for replay
+	streamRetention = 10 * time.Minute
+	// streamSubscriberBuffer is the number of lines a subscriber may fall behind
+	// before it is disconnected
+	streamSubscriberBuffer = 1024
+	// streamHeartbeatInterval keeps idle SSE connections open through proxies
+	streamHeartbeatInterval = 15 * time.Second
+)
+
+// NewStreamRegistry creates an empty registry of job output streams
+func NewStreamRegistry() *StreamRegistry {
+	return &StreamRegistry{
+		streams: make(map[string]*OutputStream),
+	}
+}
+
+// Open returns the stream for a job, creating it if needed
+func (r *StreamRegistry) Open(jobID string) *OutputStream {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if stream, ok := r.streams[jobID]; ok {
+		return stream
+	}
+
+	stream := &OutputStream{
+		subscribers: make(map[chan StreamLine]struct{}),
+		done:        make(chan struct{}),
+	}
+	r.streams[jobID] = stream
+	return stream
+}
+
+// Get returns the stream for a job if one is available
+func (r *StreamRegistry) Get(jobID string) *OutputStream {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.streams[jobID]
+}
+
+// Finish closes a job's stream with its final status and schedules its removal
+func (r *StreamRegistry) Finish(jobID, status string) {
+	r.mu.Lock()
+	stream, ok := r.streams[jobID]
+	r.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	stream.Close(status)
+	time.AfterFunc(streamRetention, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.streams[jobID] == stream {
+			delete(r.streams, jobID)
+		}
+	})
+}
+
+// Write appends a line to the stream and fans it out to all subscribers.
+// Subscribers that cannot keep up are disconnected rather than blocking the job.
+func (s *OutputStream) Write(source, text string) {
+	line := StreamLine{Stream: source, Text: text}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	s.lines = append(s.lines, line)
+	for ch := range s.subscribers {
+		select {
+		case ch <- line:
+		default:
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe returns the lines emitted so far and a channel delivering new
+// lines. The channel is closed when the stream finishes or the subscriber
+// falls behind; Done distinguishes the two.
+func (s *OutputStream) Subscribe() ([]StreamLine, <-chan StreamLine, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	history := make([]StreamLine, len(s.lines))
+	copy(history, s.lines)
+
+	ch := make(chan StreamLine, streamSubscriberBuffer)
+	if s.closed {
+		close(ch)
+		return history, ch, func() {}
+	}
+	s.subscribers[ch] = struct{}{}
+
+	unsubscribe := func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.subscribers[ch]; ok {
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+	}
+
+	return history, ch, unsubscribe
+}
+
+// Close marks the stream finished and disconnects all subscribers
+func (s *OutputStream) Close(status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	s.closed = true
+	s.status = status
+	// Close done first so subscribers seeing their channel closed can tell
+	// the stream finished rather than that they were dropped
+	close(s.done)
+	for ch := range s.subscribers {
+		delete(s.subscribers, ch)
+		close(ch)
+	}
+}
+
+// Done returns a channel that is closed once the stream has finished
+func (s *OutputStream) Done() <-chan struct{} {
+	return s.done
+}
+
+// lineWriter splits a byte stream into lines
+type lineWriter struct {
+	mu   sync.Mutex
+	buf  bytes.Buffer
+	emit func(string)
+}
+
+// newLineWriter returns a writer that calls emit once per complete line.
+// Call Flush after the producer exits to emit a trailing partial line.
+func newLineWriter(emit func(string)) *lineWriter {
+	return &lineWriter{emit: emit}
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf.Write(p)
+	for {
+		idx := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if idx < 0 {
+			break
+		}
+		line := string(w.buf.Next(idx + 1))
+		w.emit(strings.TrimRight(line, "\r\n"))
+	}
+	return len(p), nil
+}
+
+// Flush emits any buffered partial line
+func (w *lineWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.buf.Len() > 0 {
+		w.emit(strings.TrimRight(w.buf.String(), "\r\n"))
+		w.buf.Reset()
+	}
+}
+
+func (s *Server) handleJobStream(c *gin.Context) {
+	jobID := c.Param("job_id")
+
+	reqLogger := s.Logger.With().
+		Str("job_id", jobID).
+		Str("endpoint", "/api/jobs/:job_id/stream").
+		Str("remote_addr", c.ClientIP()).
+		Logger()
+
+	job, exists := s.JobStore.Get(jobID)
+	if !exists {
+		reqLogger.Warn().Msg("Job not found for stream")
+		c.JSON(404, gin.H{"error": "Job not found"})
+		return
+	}
+
+	if !s.authorizeTarget(c, job.RepositoryURL, job.PlaybookPath) {
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	stream := s.Streams.Get(jobID)
+	if stream == nil {
+		// The live stream has expired (or the service restarted); replay the stored output
+		reqLogger.Debug().Str("job_status", job.Status).Msg("No live stream, replaying stored output")
+		for _, line := range strings.Split(strings.TrimRight(job.Output, "\n"), "\n") {
+			c.SSEvent("stdout", line)
+		}
+		s.writeStreamStatus(c, jobID)
+		return
+	}
+
+	reqLogger.Info().Msg("Stream subscriber connected")
+	defer reqLogger.Info().Msg("Stream subscriber disconnected")
+
+	history, lines, unsubscribe := stream.Subscribe()
+	defer unsubscribe()
+
+	for _, line := range history {
+		c.SSEvent(line.Stream, line.Text)
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				select {
+				case <-stream.Done():
+					s.writeStreamStatus(c, jobID)
+				default:
+					c.SSEvent("error", "subscriber fell behind; reconnect to replay output")
+					c.Writer.Flush()
+				}
+				return
+			}
+			c.SSEvent(line.Stream, line.Text)
+			c.Writer.Flush()
+		case <-heartbeat.C:
+			io.WriteString(c.Writer, ": keepalive\n\n")
+			c.Writer.Flush()
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
+
+// writeStreamStatus sends the terminal SSE event carrying the job's final status
+func (s *Server) writeStreamStatus(c *gin.Context, jobID string) {
+	status := gin.H{"job_id": jobID}
+	if job, exists := s.JobStore.Get(jobID); exists {
+		status["status"] = job.Status
+		status["error"] = job.Error
+		status["end_time"] = job.EndTime
+	}
+	c.SSEvent("status", status)
+	c.Writer.Flush()
+}
@@ -0,0 +1,602 @@
+package server
+
+import (
+	"ansible-api/internal/audit"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// webhookMaxAttempts is how many times a delivery is tried before it fails
+	webhookMaxAttempts = 5
+	// webhookRetryDelay is the wait before the first retry; it doubles after each attempt
+	webhookRetryDelay = 2 * time.Second
+	// webhookTimeout bounds a single delivery request
+	webhookTimeout = 10 * time.Second
+	// maxWebhookDeliveries is how many deliveries are kept for inspection
+	maxWebhookDeliveries = 500
+	// maxWebhookResponseBytes is how much of a response body is recorded
+	maxWebhookResponseBytes = 1024
+
+	defaultWebhookQueryLimit = 50
+
+	webhookFormatJSON  = "json"
+	webhookFormatSlack = "slack"
+	webhookFormatTeams = "teams"
+
+	webhookDeliveryPending   = "pending"
+	webhookDeliveryDelivered = "delivered"
+	webhookDeliveryFailed    = "failed"
+
+	// webhookSignatureHeader carries "sha256=<hex HMAC-SHA256 of the body>"
+	webhookSignatureHeader = "X-Ansible-API-Signature-256"
+	webhookEventHeader     = "X-Ansible-API-Event"
+	webhookDeliveryHeader  = "X-Ansible-API-Delivery"
+)
+
+var (
+	errWebhookDeliveryNotFound = errors.New("webhook delivery not found")
+	errWebhookDeliveryPending  = errors.New("webhook delivery is still pending")
+)
+
+// parseWebhookTargets decodes and checks the notification_webhooks setting
+func parseWebhookTargets(value string) ([]WebhookTarget, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	var targets []WebhookTarget
+	if err := json.Unmarshal([]byte(value), &targets); err != nil {
+		return nil, fmt.Errorf("invalid notification_webhooks: %w", err)
+	}
+
+	names := make(map[string]bool)
+	for i := range targets {
+		target := &targets[i]
+		if target.Name == "" {
+			return nil, fmt.Errorf("invalid notification_webhooks: webhook %d has no name", i)
+		}
+		if names[target.Name] {
+			return nil, fmt.Errorf("invalid notification_webhooks: duplicate webhook name %q", target.Name)
+		}
+		names[target.Name] = true
+
+		parsed, err := url.Parse(target.URL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid notification_webhooks: webhook %q needs an http or https url", target.Name)
+		}
+
+		if target.Format == "" {
+			target.Format = webhookFormatJSON
+		}
+		if target.Format != webhookFormatJSON && target.Format != webhookFormatSlack && target.Format != webhookFormatTeams {
+			return nil, fmt.Errorf("invalid notification_webhooks: webhook %q has unknown format %q", target.Name, target.Format)
+		}
+
+		for _, event := range target.Events {
+			if !slices.Contains(notificationEventTypes, event) {
+				return nil, fmt.Errorf("invalid notification_webhooks: webhook %q subscribes to unknown event %q", target.Name, event)
+			}
+		}
+	}
+	return targets, nil
+}
+
+// NewWebhookNotifier opens the delivery records in dir and resumes deliveries
+// that were still pending when the service stopped
+func NewWebhookNotifier(dir string, targets []WebhookTarget) (*WebhookNotifier, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create webhook delivery directory %s: %w", dir, err)
+	}
+
+	n := &WebhookNotifier{
+		dir:        dir,
+		targets:    targets,
+		client:     &http.Client{Timeout: webhookTimeout},
+		deliveries: make(map[string]*WebhookDelivery),
+		logger:     log.With().Str("component", "webhooks").Logger(),
+	}
+	if err := n.load(); err != nil {
+		return nil, err
+	}
+
+	var pending []string
+	for id, delivery := range n.deliveries {
+		if delivery.Status == webhookDeliveryPending {
+			pending = append(pending, id)
+		}
+	}
+	for _, id := range pending {
+		go n.deliver(id)
+	}
+
+	n.logger.Info().
+		Int("targets", len(targets)).
+		Int("deliveries", len(n.deliveries)).
+		Int("resumed", len(pending)).
+		Msg("Webhook notifier started")
+	return n, nil
+}
+
+// load reads every recorded delivery
+func (n *WebhookNotifier) load() error {
+	entries, err := os.ReadDir(n.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read webhook delivery directory %s: %w", n.dir, err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		path := filepath.Join(n.dir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			n.logger.Warn().Err(err).Str("path", path).Msg("Failed to read webhook delivery, skipping")
+			continue
+		}
+
+		var delivery WebhookDelivery
+		if err := json.Unmarshal(data, &delivery); err != nil {
+			n.logger.Warn().Err(err).Str("path", path).Msg("Failed to decode webhook delivery, skipping")
+			continue
+		}
+		n.deliveries[delivery.ID] = &delivery
+	}
+	return nil
+}
+
+// Notify queues a delivery of the event to every subscribed target
+func (n *WebhookNotifier) Notify(event NotificationEvent) {
+	for _, target := range n.targets {
+		if len(target.Events) > 0 && !slices.Contains(target.Events, event.Type) {
+			continue
+		}
+
+		payload, err := renderWebhookPayload(target.Format, event)
+		if err != nil {
+			n.logger.Error().Err(err).Str("target", target.Name).Str("event", event.Type).Msg("Failed to render webhook payload")
+			continue
+		}
+
+		delivery := &WebhookDelivery{
+			Target:   target.Name,
+			Format:   target.Format,
+			Event:    event.Type,
+			EventID:  event.ID,
+			Payload:  payload,
+			Attempts: []WebhookAttempt{},
+		}
+		delivery.RepositoryURL, delivery.PlaybookPath = eventTarget(event)
+		n.queue(delivery)
+	}
+}
+
+// Redeliver sends the payload of a finished delivery again as a new delivery
+func (n *WebhookNotifier) Redeliver(id string) (*WebhookDelivery, error) {
+	n.mu.Lock()
+	original, ok := n.deliveries[id]
+	if !ok {
+		n.mu.Unlock()
+		return nil, errWebhookDeliveryNotFound
+	}
+	if original.Status == webhookDeliveryPending {
+		n.mu.Unlock()
+		return nil, errWebhookDeliveryPending
+	}
+	delivery := &WebhookDelivery{
+		Target:        original.Target,
+		Format:        original.Format,
+		Event:         original.Event,
+		EventID:       original.EventID,
+		RepositoryURL: original.RepositoryURL,
+		PlaybookPath:  original.PlaybookPath,
+		RedeliveryOf:  original.ID,
+		Payload:       original.Payload,
+		Attempts:      []WebhookAttempt{},
+	}
+	n.mu.Unlock()
+
+	n.queue(delivery)
+	return n.Delivery(delivery.ID)
+}
+
+// queue records a new delivery and starts sending it in the background
+func (n *WebhookNotifier) queue(delivery *WebhookDelivery) {
+	delivery.Status = webhookDeliveryPending
+	delivery.Created = time.Now().UTC()
+
+	n.mu.Lock()
+	// Deliveries of one event to several targets are queued back to back
+	delivery.ID = fmt.Sprintf("delivery-%d", time.Now().UnixNano())
+	for n.deliveries[delivery.ID] != nil {
+		delivery.ID = fmt.Sprintf("delivery-%d", time.Now().UnixNano())
+	}
+	n.deliveries[delivery.ID] = delivery
+	n.save(delivery)
+	n.prune()
+	n.mu.Unlock()
+
+	go n.deliver(delivery.ID)
+}
+
+// deliver sends a delivery until it succeeds, fails permanently or runs out
+// of attempts, waiting twice as long before each retry
+func (n *WebhookNotifier) deliver(id string) {
+	delay := webhookRetryDelay
+
+	for {
+		n.mu.Lock()
+		delivery, ok := n.deliveries[id]
+		if !ok || delivery.Status != webhookDeliveryPending {
+			n.mu.Unlock()
+			return
+		}
+		attempt := len(delivery.Attempts) + 1
+		request := *delivery
+		n.mu.Unlock()
+
+		result, retry := n.send(&request)
+
+		n.mu.Lock()
+		delivery.Attempts = append(delivery.Attempts, result)
+		switch {
+		case result.Error == "":
+			delivery.Status = webhookDeliveryDelivered
+		case !retry || attempt >= webhookMaxAttempts:
+			delivery.Status = webhookDeliveryFailed
+		}
+		status := delivery.Status
+		n.save(delivery)
+		n.mu.Unlock()
+
+		logEvent := n.logger.Info()
+		if result.Error != "" {
+			logEvent = n.logger.Warn().Str("error", result.Error)
+		}
+		logEvent.
+			Str("delivery_id", id).
+			Str("target", request.Target).
+			Str("event", request.Event).
+			Int("attempt", attempt).
+			Int("status_code", result.StatusCode).
+			Str("status", status).
+			Msg("Webhook delivery attempted")
+
+		if status != webhookDeliveryPending {
+			return
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// send makes one delivery request. It reports whether a failure is worth retrying.
+func (n *WebhookNotifier) send(delivery *WebhookDelivery) (attempt WebhookAttempt, retry bool) {
+	attempt.Time = time.Now().UTC()
+	defer func() {
+		attempt.DurationMs = time.Since(attempt.Time).Milliseconds()
+	}()
+
+	target, ok := n.target(delivery.Target)
+	if !ok {
+		attempt.Error = "webhook target is no longer configured"
+		return attempt, false
+	}
+
+	req, err := http.NewRequest(http.MethodPost, target.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt, false
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ansible-api-webhooks")
+	req.Header.Set(webhookEventHeader, delivery.Event)
+	req.Header.Set(webhookDeliveryHeader, delivery.ID)
+	if target.Secret != "" {
+		req.Header.Set(webhookSignatureHeader, webhookSignature(target.Secret, delivery.Payload))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		// Keep the target URL, which may hold a token, out of the record
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		attempt.Error = err.Error()
+		return attempt, true
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxWebhookResponseBytes))
+	attempt.StatusCode = resp.StatusCode
+	attempt.Response = string(body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return attempt, false
+	}
+	attempt.Error = fmt.Sprintf("unexpected status %d", resp.StatusCode)
+	return attempt, resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout
+}
+
+func (n *WebhookNotifier) target(name string) (WebhookTarget, bool) {
+	for _, target := range n.targets {
+		if target.Name == name {
+			return target, true
+		}
+	}
+	return WebhookTarget{}, false
+}
+
+// webhookSignature returns the signature header value of a payload
+func webhookSignature(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// save writes a delivery record; callers hold n.mu
+func (n *WebhookNotifier) save(delivery *WebhookDelivery) {
+	data, err := json.MarshalIndent(delivery, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(n.deliveryPath(delivery.ID), data, 0600)
+	}
+	if err != nil {
+		n.logger.Error().Err(err).Str("delivery_id", delivery.ID).Msg("Failed to record webhook delivery")
+	}
+}
+
+// prune drops the oldest finished deliveries beyond maxWebhookDeliveries;
+// callers hold n.mu
+func (n *WebhookNotifier) prune() {
+	if len(n.deliveries) <= maxWebhookDeliveries {
+		return
+	}
+
+	finished := make([]*WebhookDelivery, 0, len(n.deliveries))
+	for _, delivery := range n.deliveries {
+		if delivery.Status != webhookDeliveryPending {
+			finished = append(finished, delivery)
+		}
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].Created.Before(finished[j].Created)
+	})
+
+	for _, delivery := range finished {
+		if len(n.deliveries) <= maxWebhookDeliveries {
+			break
+		}
+		delete(n.deliveries, delivery.ID)
+		if err := os.Remove(n.deliveryPath(delivery.ID)); err != nil && !os.IsNotExist(err) {
+			n.logger.Warn().Err(err).Str("delivery_id", delivery.ID).Msg("Failed to remove webhook delivery")
+		}
+	}
+}
+
+func (n *WebhookNotifier) deliveryPath(id string) string {
+	return filepath.Join(n.dir, filepath.Base(id)+".json")
+}
+
+// Delivery returns a copy of a delivery
+func (n *WebhookNotifier) Delivery(id string) (*WebhookDelivery, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	delivery, ok := n.deliveries[id]
+	if !ok {
+		return nil, errWebhookDeliveryNotFound
+	}
+	snapshot := *delivery
+	snapshot.Attempts = slices.Clone(delivery.Attempts)
+	return &snapshot, nil
+}
+
+// Deliveries returns copies of the deliveries matching the filters, newest
+// first; empty filters match everything. allowed is asked for every delivery's
+// repository and playbook, and hides those it rejects.
+func (n *WebhookNotifier) Deliveries(target, event, status string, limit int, allowed func(repositoryURL, playbookPath string) bool) []*WebhookDelivery {
+	n.mu.Lock()
+	deliveries := make([]*WebhookDelivery, 0, len(n.deliveries))
+	for _, delivery := range n.deliveries {
+		if (target != "" && delivery.Target != target) ||
+			(event != "" && delivery.Event != event) ||
+			(status != "" && delivery.Status != status) ||
+			!allowed(delivery.RepositoryURL, delivery.PlaybookPath) {
+			continue
+		}
+		snapshot := *delivery
+		snapshot.Attempts = slices.Clone(delivery.Attempts)
+		deliveries = append(deliveries, &snapshot)
+	}
+	n.mu.Unlock()
+
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].Created.After(deliveries[j].Created)
+	})
+	if limit > 0 && len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries
+}
+
+// renderWebhookPayload builds the request body of an event in a target's format
+func renderWebhookPayload(format string, event NotificationEvent) ([]byte, error) {
+	switch format {
+	case webhookFormatSlack:
+		return json.Marshal(slackPayload(event))
+	case webhookFormatTeams:
+		return json.Marshal(teamsPayload(event))
+	}
+	return json.Marshal(event)
+}
+
+// slackPayload renders an event as a Slack incoming webhook message
+func slackPayload(event NotificationEvent) gin.H {
+	color := "good"
+	switch {
+	case eventFailed(event):
+		color = "danger"
+	case event.Type == eventDriftDetected:
+		color = "warning"
+	}
+
+	fields := []gin.H{}
+	for _, fact := range eventFacts(event) {
+		fields = append(fields, gin.H{"title": fact.Name, "value": fact.Value, "short": len(fact.Value) <= 40})
+	}
+	attachment := gin.H{
+		"fallback": event.Summary,
+		"color":    color,
+		"fields":   fields,
+		"footer":   event.Type,
+		"ts":       event.Time.Unix(),
+	}
+	if detail := eventDetail(event); detail != "" {
+		attachment["text"] = "```" + detail + "```"
+	}
+
+	return gin.H{
+		"text":        event.Summary,
+		"attachments": []gin.H{attachment},
+	}
+}
+
+// teamsPayload renders an event as a Microsoft Teams connector message card
+func teamsPayload(event NotificationEvent) gin.H {
+	color := "2EB886"
+	switch {
+	case eventFailed(event):
+		color = "D00000"
+	case event.Type == eventDriftDetected:
+		color = "DAA038"
+	}
+
+	facts := []gin.H{}
+	for _, fact := range eventFacts(event) {
+		facts = append(facts, gin.H{"name": fact.Name, "value": fact.Value})
+	}
+	section := gin.H{
+		"activityTitle":    event.Summary,
+		"activitySubtitle": event.Type + " at " + event.Time.Format(time.RFC3339),
+		"facts":            facts,
+	}
+	if detail := eventDetail(event); detail != "" {
+		section["text"] = "<pre>" + detail + "</pre>"
+	}
+
+	return gin.H{
+		"@type":      "MessageCard",
+		"@context":   "https://schema.org/extensions",
+		"summary":    event.Summary,
+		"themeColor": color,
+		"title":      event.Summary,
+		"sections":   []gin.H{section},
+	}
+}
+
+// handleWebhookDeliveries serves GET /api/notifications/deliveries
+func (s *Server) handleWebhookDeliveries(c *gin.Context) {
+	limit := defaultWebhookQueryLimit
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxWebhookDeliveries {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxWebhookDeliveries)})
+			return
+		}
+		limit = parsed
+	}
+
+	// Deliveries outside the caller's allow-list are hidden, like jobs
+	caller := principal(c)
+	allowed := func(repositoryURL, playbookPath string) bool {
+		return s.Auth.Allows(caller, repositoryURL, playbookPath)
+	}
+
+	deliveries := []*WebhookDelivery{}
+	if s.Webhooks != nil {
+		deliveries = s.Webhooks.Deliveries(c.Query("target"), c.Query("event"), c.Query("status"), limit, allowed)
+	}
+	c.JSON(200, gin.H{"deliveries": deliveries})
+}
+
+// handleWebhookDelivery serves GET /api/notifications/deliveries/:delivery_id
+func (s *Server) handleWebhookDelivery(c *gin.Context) {
+	if s.Webhooks == nil {
+		c.JSON(404, gin.H{"error": "Webhook delivery not found"})
+		return
+	}
+
+	delivery, err := s.Webhooks.Delivery(c.Param("delivery_id"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Webhook delivery not found"})
+		return
+	}
+	if !s.authorizeTarget(c, delivery.RepositoryURL, delivery.PlaybookPath) {
+		return
+	}
+	c.JSON(200, delivery)
+}
+
+// handleWebhookRedeliver serves POST /api/notifications/deliveries/:delivery_id/redeliver
+func (s *Server) handleWebhookRedeliver(c *gin.Context) {
+	id := c.Param("delivery_id")
+	if s.Webhooks == nil {
+		c.JSON(404, gin.H{"error": "Webhook delivery not found"})
+		return
+	}
+
+	original, err := s.Webhooks.Delivery(id)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Webhook delivery not found"})
+		return
+	}
+	if !s.authorizeTarget(c, original.RepositoryURL, original.PlaybookPath) {
+		return
+	}
+
+	delivery, err := s.Webhooks.Redeliver(id)
+	switch {
+	case errors.Is(err, errWebhookDeliveryNotFound):
+		c.JSON(404, gin.H{"error": "Webhook delivery not found"})
+		return
+	case errors.Is(err, errWebhookDeliveryPending):
+		c.JSON(409, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		s.Logger.Error().Err(err).Str("delivery_id", id).Msg("Failed to redeliver webhook")
+		c.JSON(500, gin.H{"error": "Failed to redeliver webhook"})
+		return
+	}
+
+	s.recordAudit(c, audit.Event{
+		Action:        "notifications.redeliver",
+		RepositoryURL: delivery.RepositoryURL,
+		PlaybookPath:  delivery.PlaybookPath,
+		Status:        delivery.Status,
+		Detail:        id + " -> " + delivery.ID,
+	})
+
+	s.Logger.Info().Str("delivery_id", id).Str("redelivery_id", delivery.ID).Msg("Webhook redelivery queued")
+	c.JSON(202, delivery)
+}
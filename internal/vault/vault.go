@@ -20,7 +20,20 @@ func init() {
 
 // VaultClient represents a Vault client
 type VaultClient struct {
-	client *vault.Client
+	client      *vault.Client
+	failureHook func(path string)
+}
+
+// SetFailureHook registers a function called with the secret path whenever a
+// Vault call fails. Secrets that simply do not exist are not failures.
+func (c *VaultClient) SetFailureHook(hook func(path string)) {
+	c.failureHook = hook
+}
+
+func (c *VaultClient) recordFailure(path string) {
+	if c.failureHook != nil {
+		c.failureHook(path)
+	}
 }
 
 func NewClient() (*VaultClient, error) {
@@ -97,6 +110,7 @@ func (c *VaultClient) GetSecret(path string) (map[string]interface{}, error) {
 			Str("path", path).
 			Str("full_path", fullPath).
 			Msg("Failed to read secret from Vault")
+		c.recordFailure(path)
 		return nil, fmt.Errorf("failed to read secret: %w", err)
 	}
 
@@ -115,6 +129,7 @@ func (c *VaultClient) GetSecret(path string) (map[string]interface{}, error) {
 			Str("path", path).
 			Str("full_path", fullPath).
 			Msg("Invalid secret data format")
+		c.recordFailure(path)
 		return nil, fmt.Errorf("invalid secret data format")
 	}
 
@@ -178,6 +193,7 @@ func (c *VaultClient) GetSSHKey() (string, error) {
 	secret, err := c.client.Logical().Read("kv/data/ansible/ssh-key")
 	if err != nil {
 		logger.Error().Msg("Failed to read SSH key")
+		c.recordFailure("ansible/ssh-key")
 		return "", fmt.Errorf("failed to read SSH key from Vault: %w", err)
 	}
 